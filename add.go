@@ -1,11 +1,16 @@
 package buildah
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -14,34 +19,215 @@ import (
 	"github.com/containers/storage/pkg/archive"
 )
 
-// addUrl copies the contents of the source URL to the destination.  This is
-// its own function so that deferred closes happen after we're done pulling
-// down each item of potentially many.
-func addUrl(destination, srcurl string) error {
-	logrus.Debugf("saving %q to %q", srcurl, destination)
-	resp, err := http.Get(srcurl)
+// AddAndCopyOptions holds options for the Add() method.
+type AddAndCopyOptions struct {
+	// ExtractURLs signals to Add() that contents downloaded from URLs
+	// which look like archives should be extracted into the destination
+	// directory instead of being saved as files.
+	ExtractURLs bool
+	// URL controls how contents are retrieved from http and https URLs.
+	URL URLOptions
+}
+
+// urlPeekSize is how much of a download we look at when deciding whether or
+// not it's an archive.  A compressed tar header should fit in much less.
+const urlPeekSize = 64 * 1024
+
+// isTarball returns true if the start of some possibly-compressed contents
+// decompresses to a tar header, the way archive.IsArchivePath() checks files.
+func isTarball(header []byte) bool {
+	rdr, err := archive.DecompressStream(bytes.NewReader(header))
+	if err != nil {
+		return false
+	}
+	defer rdr.Close()
+	_, err = tar.NewReader(rdr).Next()
+	return err == nil
+}
+
+// addUrl copies the contents of the source URL to the destination directory,
+// either as a file named after the last component of the URL's path, or, if
+// extract is set and the contents are a possibly-compressed tarball, by
+// extracting them as they're downloaded.  This is its own function so that deferred closes
+// happen after we're done pulling down each item of potentially many.
+func addUrl(client *http.Client, destination, srcurl string, extract bool, options URLOptions) error {
+	url, err := url.Parse(srcurl)
+	if err != nil {
+		return fmt.Errorf("error parsing URL %q: %v", srcurl, err)
+	}
+	resp, err := getURL(client, srcurl, options)
 	if err != nil {
-		return fmt.Errorf("error getting %q: %v", srcurl, err)
+		return err
 	}
 	defer resp.Body.Close()
-	f, err := os.Create(destination)
+	body := bufio.NewReaderSize(resp.Body, urlPeekSize)
+	if extract {
+		header, err := body.Peek(urlPeekSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading contents of %q: %v", srcurl, err)
+		}
+		if isTarball(header) {
+			logrus.Debugf("extracting contents of %q into %q", srcurl, destination)
+			if err := archive.Untar(body, destination, nil); err != nil {
+				return fmt.Errorf("error extracting %q into %q: %v", srcurl, destination, err)
+			}
+			return nil
+		}
+	}
+	d := filepath.Join(destination, path.Base(url.Path))
+	logrus.Debugf("saving %q to %q", srcurl, d)
+	f, err := os.Create(d)
 	if err != nil {
-		return fmt.Errorf("error creating %q: %v", destination, err)
+		return fmt.Errorf("error creating %q: %v", d, err)
 	}
 	defer f.Close()
-	n, err := io.Copy(f, resp.Body)
+	n, err := io.Copy(f, body)
+	if err != nil {
+		return fmt.Errorf("error reading contents for %q: %v", d, err)
+	}
 	if resp.ContentLength >= 0 && n != resp.ContentLength {
-		return fmt.Errorf("error reading contents for %q: wrong length (%d != %d)", destination, n, resp.ContentLength)
+		return fmt.Errorf("error reading contents for %q: wrong length (%d != %d)", d, n, resp.ContentLength)
 	}
 	if err := f.Chmod(0755); err != nil {
-		return fmt.Errorf("error setting permissions on %q: %v", destination, err)
+		return fmt.Errorf("error setting permissions on %q: %v", d, err)
+	}
+	return nil
+}
+
+// isGitSource returns true if the source names a git repository which we
+// should clone rather than download: either a git:// URL, or an http(s)://
+// or file:// URL whose path ends in ".git".
+func isGitSource(src string) bool {
+	if strings.HasPrefix(src, "git://") {
+		return true
+	}
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "file://") {
+		repo := strings.SplitN(src, "#", 2)[0]
+		return strings.HasSuffix(repo, ".git")
+	}
+	return false
+}
+
+// parseGitSource splits a git source of the form repository#ref:subdir into
+// its component parts.  Both the ref and the subdirectory are optional.
+func parseGitSource(src string) (repo, ref, subdir string) {
+	parts := strings.SplitN(src, "#", 2)
+	repo = parts[0]
+	if len(parts) > 1 {
+		fragment := strings.SplitN(parts[1], ":", 2)
+		ref = fragment[0]
+		if len(fragment) > 1 {
+			subdir = fragment[1]
+		}
+	}
+	return repo, ref, subdir
+}
+
+// runGit runs the local git binary with the specified arguments in the
+// specified directory.
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running \"git %s\": %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// addGit clones the repository named by the source into a temporary
+// directory, checks out the requested ref, and copies the contents of the
+// requested subdirectory, minus the .git directories and files of the
+// repository and its submodules, to the destination.
+func addGit(destination, src string) error {
+	repo, ref, subdir := parseGitSource(src)
+	path, err := ioutil.TempDir(os.TempDir(), Package)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := os.RemoveAll(path); err2 != nil {
+			logrus.Errorf("error removing %q: %v", path, err2)
+		}
+	}()
+	logrus.Debugf("cloning %q into %q", repo, path)
+	if err = runGit(path, "clone", "-q", "--recursive", "--", repo, "."); err != nil {
+		return fmt.Errorf("error cloning %q: %v", repo, err)
+	}
+	if ref != "" {
+		// Don't let the ref be mistaken for an option.
+		if strings.HasPrefix(ref, "-") {
+			return fmt.Errorf("invalid ref %q for %q", ref, repo)
+		}
+		if err = runGit(path, "checkout", "-q", ref, "--"); err != nil {
+			return fmt.Errorf("error checking out %q from %q: %v", ref, repo, err)
+		}
+		if err = runGit(path, "submodule", "update", "-q", "--init", "--recursive"); err != nil {
+			return fmt.Errorf("error updating submodules of %q: %v", repo, err)
+		}
+	}
+	// Don't let the subdirectory take us out of the checkout.
+	dir := filepath.Join(path, filepath.Clean(string(os.PathSeparator)+subdir))
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("error reading %q in %q: %v", subdir, repo, err)
+	}
+	if !fi.Mode().IsDir() {
+		return fmt.Errorf("%q in %q is not a directory", subdir, repo)
+	}
+	// Remove the .git files and directories of the repository and any
+	// submodules, wherever they are, so that we don't copy them.
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Name() != ".git" {
+			return nil
+		}
+		if err = os.RemoveAll(path); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error removing git metadata from %q: %v", dir, err)
+	}
+	logrus.Debugf("copying %q to %q", dir+string(os.PathSeparator)+"*", destination+string(os.PathSeparator)+"*")
+	rc, err := archive.TarWithOptions(dir, &archive.TarOptions{})
+	if err != nil {
+		return fmt.Errorf("error reading contents of %q: %v", src, err)
+	}
+	defer rc.Close()
+	if err = archive.Untar(rc, destination, nil); err != nil {
+		return fmt.Errorf("error copying contents of %q to %q: %v", src, destination, err)
 	}
 	return nil
 }
 
 // Add copies contents into the container's root filesystem, optionally
-// extracting contents of local files that look like non-empty archives.
-func (b *Builder) Add(destination string, extract bool, source ...string) error {
+// extracting contents of local files that look like non-empty archives, and,
+// if options.ExtractURLs is set, of downloaded contents which look like
+// archives.
+// Sources which name git repositories, optionally followed by "#ref:subdir",
+// are cloned, and the contents of the checkout are copied.
+// The step is recorded in the Builder's history.
+func (b *Builder) Add(destination string, extract bool, options AddAndCopyOptions, source ...string) error {
+	if err := b.add(destination, extract, options, source...); err != nil {
+		return err
+	}
+	instruction := "COPY"
+	if extract {
+		instruction = "ADD"
+	}
+	b.AddHistory(fmt.Sprintf("%s %s %s", instruction, strings.Join(source, " "), destination), false)
+	return nil
+}
+
+func (b *Builder) add(destination string, extract bool, options AddAndCopyOptions, source ...string) error {
 	if b.MountPoint == "" {
 		return fmt.Errorf("build container is not mounted")
 	}
@@ -58,17 +244,23 @@ func (b *Builder) Add(destination string, extract bool, source ...string) error
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return fmt.Errorf("error ensuring directory %q exists: %v)", dest, err)
 	}
+	copier := newCopier()
+	client, err := newHTTPClient(options.URL)
+	if err != nil {
+		return err
+	}
 	for _, src := range source {
+		if isGitSource(src) {
+			if err := addGit(dest, src); err != nil {
+				return err
+			}
+			continue
+		}
 		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
 			// We assume that source is a file, and we're copying
-			// it to the destination.  Compute a filename and save
-			// the contents.
-			url, err := url.Parse(src)
-			if err != nil {
-				return fmt.Errorf("error parsing URL %q: %v", src, err)
-			}
-			d := filepath.Join(dest, path.Base(url.Path))
-			if err := addUrl(d, src); err != nil {
+			// it to the destination, unless we've been asked to
+			// extract archives.
+			if err := addUrl(client, dest, src, extract && options.ExtractURLs, options.URL); err != nil {
 				return err
 			}
 			continue
@@ -87,7 +279,7 @@ func (b *Builder) Add(destination string, extract bool, source ...string) error
 				return fmt.Errorf("error ensuring directory %q exists: %v)", dest, err)
 			}
 			logrus.Debugf("copying %q to %q", src+string(os.PathSeparator)+"*", d+string(os.PathSeparator)+"*")
-			if err := archive.CopyWithTar(src, d); err != nil {
+			if err := copier.copyTree(src, d); err != nil {
 				return fmt.Errorf("error copying %q to %q: %v", src, d, err)
 			}
 			continue
@@ -99,7 +291,7 @@ func (b *Builder) Add(destination string, extract bool, source ...string) error
 			d := filepath.Join(dest, filepath.Base(src))
 			// Copy the file, preserving attributes.
 			logrus.Debugf("copying %q to %q", src, d)
-			if err := archive.CopyFileWithTar(src, d); err != nil {
+			if err := copier.copyFile(src, d); err != nil {
 				return fmt.Errorf("error copying %q to %q: %v", src, d, err)
 			}
 			continue
@@ -0,0 +1,704 @@
+package buildah
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	ociLayout "github.com/containers/image/oci/layout"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// archiveTransport is an ImageTransport for images which are kept in
+// tarballs, either containing an OCI image layout, or in the format which is
+// produced by "docker save" and consumed by "docker load".  Neither is
+// provided by the version of containers/image that we use, so we register
+// them ourselves.
+type archiveTransport struct {
+	name string
+}
+
+var (
+	// OCIArchiveTransport reads and writes tarballs which contain an OCI
+	// image layout.  References take the form "path[:ref]", where ref
+	// defaults to "latest".
+	OCIArchiveTransport types.ImageTransport = archiveTransport{name: "oci-archive"}
+	// DockerArchiveTransport reads and writes tarballs in the format which
+	// is produced by "docker save".  References take the form
+	// "path[:name[:tag]]".
+	DockerArchiveTransport types.ImageTransport = archiveTransport{name: "docker-archive"}
+
+	// ociRefRegexp matches the names which can be given to images in an
+	// OCI image layout.
+	ociRefRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+func init() {
+	for _, t := range []types.ImageTransport{OCIArchiveTransport, DockerArchiveTransport} {
+		if _, ok := transports.KnownTransports[t.Name()]; !ok {
+			transports.KnownTransports[t.Name()] = t
+		}
+	}
+}
+
+func (t archiveTransport) Name() string {
+	return t.name
+}
+
+func (t archiveTransport) ParseReference(ref string) (types.ImageReference, error) {
+	if t == OCIArchiveTransport {
+		path, tag := ref, "latest"
+		if sep := strings.LastIndex(ref, ":"); sep != -1 {
+			path, tag = ref[:sep], ref[sep+1:]
+		}
+		if !ociRefRegexp.MatchString(tag) {
+			return nil, fmt.Errorf("invalid reference name %q", tag)
+		}
+		return &archiveReference{transport: t, path: path, tag: tag}, nil
+	}
+	fields := strings.SplitN(ref, ":", 2)
+	archive := &archiveReference{transport: t, path: fields[0]}
+	if len(fields) > 1 && fields[1] != "" {
+		named, err := parseTaggedName(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		archive.named = named
+	}
+	return archive, nil
+}
+
+func (t archiveTransport) ValidatePolicyConfigurationScope(scope string) error {
+	if !filepath.IsAbs(scope) {
+		return fmt.Errorf("invalid scope %q: must be an absolute path", scope)
+	}
+	return nil
+}
+
+// parseTaggedName parses an image name, normalizing it the way that "docker"
+// does, and adding the "latest" tag if none was specified.
+func parseTaggedName(name string) (reference.NamedTagged, error) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing image name %q: %v", name, err)
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return nil, fmt.Errorf("image name %q is not a tag", name)
+	}
+	return tagged, nil
+}
+
+// archiveReference is a reference to an image in a tarball.
+type archiveReference struct {
+	transport archiveTransport
+	path      string
+	// tag is the name of the image in an OCI layout.
+	tag string
+	// named is the name of the image in a docker-archive tarball.
+	named reference.NamedTagged
+	// tags are additional names to give the image when writing it.
+	tags []string
+}
+
+func (r *archiveReference) Transport() types.ImageTransport {
+	return r.transport
+}
+
+func (r *archiveReference) StringWithinTransport() string {
+	if r.transport == OCIArchiveTransport {
+		return r.path + ":" + r.tag
+	}
+	if r.named != nil {
+		return r.path + ":" + reference.FamiliarString(r.named)
+	}
+	return r.path
+}
+
+func (r *archiveReference) DockerReference() reference.Named {
+	if r.named == nil {
+		return nil
+	}
+	return r.named
+}
+
+func (r *archiveReference) PolicyConfigurationIdentity() string {
+	path, err := filepath.Abs(r.path)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func (r *archiveReference) PolicyConfigurationNamespaces() []string {
+	return nil
+}
+
+func (r *archiveReference) NewImage(sc *types.SystemContext) (types.Image, error) {
+	src, err := r.NewImageSource(sc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return image.FromSource(src)
+}
+
+func (r *archiveReference) NewImageSource(sc *types.SystemContext, manifestTypes []string) (types.ImageSource, error) {
+	dir, err := ioutil.TempDir("", r.transport.name)
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	if err = extractArchive(r.path, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if r.transport == OCIArchiveTransport {
+		layoutRef, err := ociLayout.NewReference(dir, r.tag)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		layout, err := layoutRef.NewImageSource(sc, manifestTypes)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		return &ociArchiveImageSource{ImageSource: layout, ref: r, dir: dir}, nil
+	}
+	src, err := newDockerArchiveImageSource(r, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return src, nil
+}
+
+func (r *archiveReference) NewImageDestination(sc *types.SystemContext) (types.ImageDestination, error) {
+	dir, err := ioutil.TempDir("", r.transport.name)
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	if r.transport == OCIArchiveTransport {
+		for _, tag := range r.tags {
+			if !ociRefRegexp.MatchString(tag) {
+				os.RemoveAll(dir)
+				return nil, fmt.Errorf("invalid reference name %q", tag)
+			}
+		}
+		layoutRef, err := ociLayout.NewReference(dir, r.tag)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		layout, err := layoutRef.NewImageDestination(sc)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		return &ociArchiveImageDestination{ImageDestination: layout, ref: r, dir: dir}, nil
+	}
+	repoTags := []string{}
+	if r.named != nil {
+		repoTags = append(repoTags, reference.FamiliarString(r.named))
+	}
+	for _, tag := range r.tags {
+		named, err := parseTaggedName(tag)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		repoTags = append(repoTags, reference.FamiliarString(named))
+	}
+	return &dockerArchiveImageDestination{ref: r, dir: dir, repoTags: repoTags}, nil
+}
+
+func (r *archiveReference) DeleteImage(sc *types.SystemContext) error {
+	return fmt.Errorf("deleting images from %s tarballs is not supported", r.transport.name)
+}
+
+// archiveFile is an entry which writeArchive adds to a tarball, with its
+// contents taken either from a file, or from data.
+type archiveFile struct {
+	name   string
+	source string
+	data   []byte
+}
+
+// writeArchive writes a tarball containing the files to path.  The tarball is
+// written to a temporary file which then replaces path, so that we never
+// leave a partially-written tarball at path.
+func writeArchive(path string, files []archiveFile) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	tw := tar.NewWriter(f)
+	for _, file := range files {
+		if err = writeArchiveFile(tw, file); err != nil {
+			return fmt.Errorf("error writing %q to %q: %v", file.name, path, err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("error writing %q: %v", path, err)
+	}
+	if err = f.Chmod(0644); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// writeArchiveFile adds one entry to a tarball.
+func writeArchiveFile(tw *tar.Writer, file archiveFile) error {
+	hdr := &tar.Header{
+		Name:     file.name,
+		Mode:     0644,
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(file.data)),
+	}
+	if file.source == "" {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(file.data)
+		return err
+	}
+	src, err := os.Open(file.source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr.Size = st.Size()
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+// ociArchiveImageDestination writes an image to an OCI layout in a temporary
+// directory, and then writes the contents of that directory to a tarball.
+type ociArchiveImageDestination struct {
+	types.ImageDestination
+	ref *archiveReference
+	dir string
+}
+
+func (d *ociArchiveImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+func (d *ociArchiveImageDestination) Close() {
+	d.ImageDestination.Close()
+	os.RemoveAll(d.dir)
+}
+
+func (d *ociArchiveImageDestination) Commit() error {
+	if err := d.ImageDestination.Commit(); err != nil {
+		return err
+	}
+	if err := addOCILayoutTags(d.dir, d.ref.tag, d.ref.tags); err != nil {
+		return err
+	}
+	files := []archiveFile{}
+	err := filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, archiveFile{name: filepath.ToSlash(name), source: path})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading OCI layout in %q: %v", d.dir, err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return writeArchive(d.ref.path, files)
+}
+
+// addOCILayoutTags gives the image which is known as tag in the OCI layout in
+// dir each of the additional names in tags.
+func addOCILayoutTags(dir, tag string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	descriptor, err := ioutil.ReadFile(filepath.Join(dir, "refs", tag))
+	if err != nil {
+		return fmt.Errorf("error reading descriptor for %q: %v", tag, err)
+	}
+	for _, t := range tags {
+		if !ociRefRegexp.MatchString(t) {
+			return fmt.Errorf("invalid reference name %q", t)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, "refs", t), descriptor, 0644); err != nil {
+			return fmt.Errorf("error writing descriptor for %q: %v", t, err)
+		}
+	}
+	return nil
+}
+
+// dockerArchiveImageDestination collects an image's blobs in a temporary
+// directory, and then writes them to a tarball in the format which "docker
+// load" expects.
+type dockerArchiveImageDestination struct {
+	ref      *archiveReference
+	dir      string
+	repoTags []string
+	manifest []byte
+}
+
+// dockerArchiveManifestItem is an entry in a docker-archive's manifest.json.
+type dockerArchiveManifestItem struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func (d *dockerArchiveImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+func (d *dockerArchiveImageDestination) Close() {
+	os.RemoveAll(d.dir)
+}
+
+func (d *dockerArchiveImageDestination) SupportedManifestMIMETypes() []string {
+	return []string{manifest.DockerV2Schema2MediaType}
+}
+
+func (d *dockerArchiveImageDestination) SupportsSignatures() error {
+	return fmt.Errorf("docker-archive tarballs can not hold signatures")
+}
+
+func (d *dockerArchiveImageDestination) ShouldCompressLayers() bool {
+	return false
+}
+
+func (d *dockerArchiveImageDestination) AcceptsForeignLayerURLs() bool {
+	return false
+}
+
+func (d *dockerArchiveImageDestination) blobPath(blobDigest digest.Digest) string {
+	return filepath.Join(d.dir, blobDigest.Hex())
+}
+
+func (d *dockerArchiveImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
+	blobFile, err := ioutil.TempFile(d.dir, "blob")
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	defer blobFile.Close()
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(blobFile, io.TeeReader(stream, digester.Hash()))
+	if err != nil {
+		os.Remove(blobFile.Name())
+		return types.BlobInfo{}, err
+	}
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		os.Remove(blobFile.Name())
+		return types.BlobInfo{}, fmt.Errorf("size mismatch when copying %s, expected %d, got %d", digester.Digest(), inputInfo.Size, size)
+	}
+	if err = os.Rename(blobFile.Name(), d.blobPath(digester.Digest())); err != nil {
+		os.Remove(blobFile.Name())
+		return types.BlobInfo{}, err
+	}
+	return types.BlobInfo{Digest: digester.Digest(), Size: size}, nil
+}
+
+func (d *dockerArchiveImageDestination) HasBlob(info types.BlobInfo) (bool, int64, error) {
+	if info.Digest == "" {
+		return false, -1, fmt.Errorf("can not check for a blob with unknown digest")
+	}
+	st, err := os.Stat(d.blobPath(info.Digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, -1, types.ErrBlobNotFound
+		}
+		return false, -1, err
+	}
+	return true, st.Size(), nil
+}
+
+func (d *dockerArchiveImageDestination) ReapplyBlob(info types.BlobInfo) (types.BlobInfo, error) {
+	return info, nil
+}
+
+func (d *dockerArchiveImageDestination) PutManifest(m []byte) error {
+	d.manifest = append([]byte{}, m...)
+	return nil
+}
+
+func (d *dockerArchiveImageDestination) PutSignatures(signatures [][]byte) error {
+	if len(signatures) != 0 {
+		return d.SupportsSignatures()
+	}
+	return nil
+}
+
+func (d *dockerArchiveImageDestination) Commit() error {
+	parsed := struct {
+		Config struct {
+			Digest digest.Digest `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest digest.Digest `json:"digest"`
+		} `json:"layers"`
+	}{}
+	if err := json.Unmarshal(d.manifest, &parsed); err != nil {
+		return fmt.Errorf("error parsing manifest: %v", err)
+	}
+	item := dockerArchiveManifestItem{
+		Config:   parsed.Config.Digest.Hex() + ".json",
+		RepoTags: d.repoTags,
+		Layers:   []string{},
+	}
+	files := []archiveFile{{name: item.Config, source: d.blobPath(parsed.Config.Digest)}}
+	written := make(map[digest.Digest]bool)
+	for _, layer := range parsed.Layers {
+		name := layer.Digest.Hex() + "/layer.tar"
+		item.Layers = append(item.Layers, name)
+		if !written[layer.Digest] {
+			files = append(files, archiveFile{name: name, source: d.blobPath(layer.Digest)})
+			written[layer.Digest] = true
+		}
+	}
+	index, err := json.Marshal([]dockerArchiveManifestItem{item})
+	if err != nil {
+		return err
+	}
+	files = append(files, archiveFile{name: "manifest.json", data: index})
+	return writeArchive(d.ref.path, files)
+}
+
+// extractArchive extracts the directories and regular files in the tarball at
+// path into dir, which is all that either kind of archive should contain.
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %v", path, err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %q: %v", path, err)
+		}
+		name := filepath.Clean(string(os.PathSeparator) + hdr.Name)
+		if name == string(os.PathSeparator) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("error creating %q: %v", target, err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("error creating %q: %v", filepath.Dir(target), err)
+			}
+			if err = extractArchiveFile(tr, target); err != nil {
+				return err
+			}
+		default:
+			logrus.Debugf("ignoring %q in %q", hdr.Name, path)
+		}
+	}
+}
+
+func extractArchiveFile(r io.Reader, target string) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating %q: %v", target, err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		return fmt.Errorf("error writing %q: %v", target, err)
+	}
+	return nil
+}
+
+// ociArchiveImageSource reads an image from an OCI layout which was extracted
+// from a tarball into a temporary directory.
+type ociArchiveImageSource struct {
+	types.ImageSource
+	ref *archiveReference
+	dir string
+}
+
+func (s *ociArchiveImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *ociArchiveImageSource) Close() {
+	s.ImageSource.Close()
+	os.RemoveAll(s.dir)
+}
+
+// dockerArchiveManifest is the schema 2 manifest which we construct for an
+// image in a docker-archive tarball, which doesn't include one.
+type dockerArchiveManifest struct {
+	specs.Versioned
+	MediaType string          `json:"mediaType"`
+	Config    v1.Descriptor   `json:"config"`
+	Layers    []v1.Descriptor `json:"layers"`
+}
+
+// dockerArchiveImageSource reads an image from a docker-archive tarball which
+// was extracted into a temporary directory.
+type dockerArchiveImageSource struct {
+	ref      *archiveReference
+	dir      string
+	manifest []byte
+	// blobs maps the digests of the image's configuration and layers to
+	// the names of the files which hold them.
+	blobs map[digest.Digest]string
+}
+
+// newDockerArchiveImageSource reads the index of an extracted docker-archive
+// tarball, picks out the image that the reference names, or the only image
+// if it doesn't name one, and builds a manifest for it.
+func newDockerArchiveImageSource(r *archiveReference, dir string) (*dockerArchiveImageSource, error) {
+	index, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest.json from %q: %v", r.path, err)
+	}
+	items := []dockerArchiveManifestItem{}
+	if err = json.Unmarshal(index, &items); err != nil {
+		return nil, fmt.Errorf("error parsing manifest.json from %q: %v", r.path, err)
+	}
+	var item *dockerArchiveManifestItem
+	if r.named == nil {
+		if len(items) != 1 {
+			return nil, fmt.Errorf("%q contains %d images, and no name was given to choose one", r.path, len(items))
+		}
+		item = &items[0]
+	} else {
+	search:
+		for i := range items {
+			for _, tag := range items[i].RepoTags {
+				named, err := parseTaggedName(tag)
+				if err == nil && named.String() == r.named.String() {
+					item = &items[i]
+					break search
+				}
+			}
+		}
+		if item == nil {
+			return nil, fmt.Errorf("no image named %q found in %q", reference.FamiliarString(r.named), r.path)
+		}
+	}
+
+	s := &dockerArchiveImageSource{ref: r, dir: dir, blobs: make(map[digest.Digest]string)}
+	config, err := s.describe(item.Config, manifest.DockerV2Schema2ConfigMediaType)
+	if err != nil {
+		return nil, err
+	}
+	m := dockerArchiveManifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: manifest.DockerV2Schema2MediaType,
+		Config:    config,
+		Layers:    []v1.Descriptor{},
+	}
+	for _, layer := range item.Layers {
+		desc, err := s.describe(layer, manifest.DockerV2Schema2LayerMediaType)
+		if err != nil {
+			return nil, err
+		}
+		m.Layers = append(m.Layers, desc)
+	}
+	if s.manifest, err = json.Marshal(&m); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// describe computes the digest and size of a file in the extracted tarball,
+// and remembers it so that GetBlob can find it later.
+func (s *dockerArchiveImageSource) describe(name, mediaType string) (v1.Descriptor, error) {
+	path := filepath.Join(s.dir, filepath.Clean(string(os.PathSeparator)+name))
+	f, err := os.Open(path)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("error opening %q in %q: %v", name, s.ref.path, err)
+	}
+	defer f.Close()
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("error reading %q in %q: %v", name, s.ref.path, err)
+	}
+	s.blobs[digester.Digest()] = path
+	return v1.Descriptor{MediaType: mediaType, Digest: digester.Digest(), Size: size}, nil
+}
+
+func (s *dockerArchiveImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *dockerArchiveImageSource) Close() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *dockerArchiveImageSource) GetManifest() ([]byte, string, error) {
+	return s.manifest, manifest.DockerV2Schema2MediaType, nil
+}
+
+func (s *dockerArchiveImageSource) GetTargetManifest(digest digest.Digest) ([]byte, string, error) {
+	return nil, "", fmt.Errorf("%s tarballs don't contain manifest lists", s.ref.transport.name)
+}
+
+func (s *dockerArchiveImageSource) GetBlob(info types.BlobInfo) (io.ReadCloser, int64, error) {
+	path, ok := s.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("no blob with digest %q in %q", info.Digest, s.ref.path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, -1, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, -1, err
+	}
+	return f, st.Size(), nil
+}
+
+func (s *dockerArchiveImageSource) GetSignatures() ([][]byte, error) {
+	return nil, nil
+}
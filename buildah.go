@@ -9,6 +9,8 @@ import (
 
 	"github.com/containers/storage/pkg/ioutils"
 	"github.com/containers/storage/storage"
+	"github.com/docker/docker/api/types/container"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
@@ -93,6 +95,57 @@ type Builder struct {
 	Volumes []string `json:"volumes,omitempty"`
 	// Arg is a set of build-time variables.
 	Arg map[string]string `json:"arg,omitempty"`
+
+	// RemovedEnv is a list of names of environment variables which will be
+	// removed from the source image's configuration.
+	RemovedEnv []string `json:"removed-env,omitempty"`
+	// RemovedLabels is a list of labels which will be removed from the
+	// source image's configuration.
+	RemovedLabels []string `json:"removed-labels,omitempty"`
+	// RemovedVolumes is a list of volumes which will be removed from the
+	// source image's configuration.
+	RemovedVolumes []string `json:"removed-volumes,omitempty"`
+	// RemovedPorts is a list of exposed ports which will be removed from
+	// the source image's configuration.
+	RemovedPorts []string `json:"removed-ports,omitempty"`
+	// CmdCleared signals that the source image's command should be
+	// discarded, if Cmd is not set.
+	CmdCleared bool `json:"cmd-cleared,omitempty"`
+	// EntrypointCleared signals that the source image's entry point should
+	// be discarded, if Entrypoint is not set.
+	EntrypointCleared bool `json:"entrypoint-cleared,omitempty"`
+
+	// Healthcheck describes how to check that a container based on the
+	// image is healthy.  It is only stored in Docker-format configuration
+	// fields.
+	Healthcheck *container.HealthConfig `json:"healthcheck,omitempty"`
+	// StopSignal is the signal which should be used to stop containers
+	// based on the image.  It is only stored in Docker-format
+	// configuration fields.
+	StopSignal string `json:"stop-signal,omitempty"`
+	// Shell is the shell which should be used for shell-form commands.
+	// It is only stored in Docker-format configuration fields.
+	Shell []string `json:"shell,omitempty"`
+	// OnBuild is a list of instructions which should be run when the image
+	// is used as the base for another image.  It is only stored in
+	// Docker-format configuration fields.
+	OnBuild []string `json:"onbuild,omitempty"`
+	// OnBuildTriggers is the list of ONBUILD instructions which were set
+	// in the source image, and which can be run using RunOnBuild(), which
+	// clears it.  It should not be modified.
+	OnBuildTriggers []string `json:"onbuild-triggers,omitempty"`
+
+	// History is a list of the steps which have been taken to build the
+	// container, which will be added to the source image's history when
+	// the container is committed.
+	History []ociv1.History `json:"history,omitempty"`
+	// CommittedHistory is the number of entries at the start of History
+	// whose changes have been stored in read-only layers by StoreLayer().
+	CommittedHistory int `json:"committed-history,omitempty"`
+	// IntermediateImages are the IDs of the unnamed images which
+	// StoreLayer() created to hold the layers that it stored, oldest
+	// first.  They are removed when the container is deleted.
+	IntermediateImages []string `json:"intermediate-images,omitempty"`
 }
 
 // BuilderOptions are used to initialize a Builder.
@@ -120,6 +173,24 @@ type BuilderOptions struct {
 	// Link specifies a location for a symbolic link which should be
 	// created if the container is mounted immediately.
 	Link string
+	// RootFS is the location of a directory tree, and Tarball is the
+	// location of a possibly-compressed tarball, which should be used to
+	// populate the root filesystem of a container which is not based on
+	// an image.  At most one of them can be specified.
+	RootFS  string
+	Tarball string
+	// OS, Architecture, and Variant select which image to use when the
+	// source image is a manifest list.  OS and Architecture default to
+	// the ones we're running on, and any variant is accepted if Variant
+	// is not set.  If OS or Architecture is set, the source image must be
+	// for that platform, and the value is recorded as the default for
+	// images committed from the container.  Images which are pulled for a
+	// specific platform are named using the name that they were pulled
+	// using followed by "@OS/ARCH[/VARIANT]", so that they don't replace
+	// the image for the platform that we're running on.
+	OS           string
+	Architecture string
+	Variant      string
 	// SignaturePolicyPath specifies an override location for the signature
 	// policy which should be used for verifying the new image as it is
 	// being written.  Except in specific circumstances, no value should be
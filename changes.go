@@ -0,0 +1,103 @@
+package buildah
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/storage/pkg/archive"
+)
+
+const (
+	// ChangeAdded marks a Change which describes a path that was added.
+	ChangeAdded = "A"
+	// ChangeModified marks a Change which describes a path that was
+	// modified.
+	ChangeModified = "C"
+	// ChangeDeleted marks a Change which describes a path that was
+	// deleted.
+	ChangeDeleted = "D"
+)
+
+// Change describes a difference between a working container's root
+// filesystem and the image that it was created from.
+type Change struct {
+	// Kind is one of ChangeAdded, ChangeModified, or ChangeDeleted.
+	Kind string `json:"kind"`
+	// Path is the absolute location of the item in the container.
+	Path string `json:"path"`
+	// Size is the size of the item if it is a regular file or a symbolic
+	// link which is present in the container, and zero otherwise.
+	Size int64 `json:"size"`
+}
+
+// Changes lists the items which have been added, modified, or deleted in the
+// working container's root filesystem, compared to the container's parent
+// layer, sorted by location.  The parent layer is the topmost layer of the
+// image that the container was created from, unless changes have since been
+// moved into a layer using StoreLayer(), in which case it is that layer.  If
+// any paths are specified, only changes to those paths and their contents are
+// listed.
+func (b *Builder) Changes(paths ...string) ([]Change, error) {
+	mountPoint, err := b.store.Mount(b.ContainerID, "")
+	if err != nil {
+		return nil, fmt.Errorf("error mounting build container %q: %v", b.ContainerID, err)
+	}
+	defer func() {
+		if err2 := b.store.Unmount(b.ContainerID); err2 != nil {
+			logrus.Errorf("error unmounting build container %q: %v", b.ContainerID, err2)
+		}
+	}()
+
+	container, err := b.store.GetContainer(b.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build container %q: %v", b.ContainerID, err)
+	}
+	changes, err := b.store.Changes("", container.LayerID)
+	if err != nil {
+		return nil, fmt.Errorf("error computing changes in layer %q: %v", container.LayerID, err)
+	}
+
+	dirs := []string{}
+	for _, path := range paths {
+		dirs = append(dirs, filepath.Clean(string(os.PathSeparator)+path))
+	}
+	results := []Change{}
+	for _, change := range changes {
+		path := filepath.Clean(string(os.PathSeparator) + change.Path)
+		if len(dirs) > 0 && !underAny(path, dirs) {
+			continue
+		}
+		result := Change{Path: path}
+		switch change.Kind {
+		case archive.ChangeAdd:
+			result.Kind = ChangeAdded
+		case archive.ChangeModify:
+			result.Kind = ChangeModified
+		case archive.ChangeDelete:
+			result.Kind = ChangeDeleted
+		}
+		if result.Kind != ChangeDeleted {
+			if fi, err := os.Lstat(filepath.Join(mountPoint, path)); err == nil && (fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0) {
+				result.Size = fi.Size()
+			}
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// underAny checks if path is one of the locations in dirs, or is somewhere
+// below one of them.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || dir == string(os.PathSeparator) || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
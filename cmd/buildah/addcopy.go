@@ -2,12 +2,15 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/projectatomic/buildah"
 	"github.com/urfave/cli"
 )
 
 var (
-	addFlags = []cli.Flag{
+	addAndCopyFlags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "name or ID of the working container",
@@ -24,10 +27,102 @@ var (
 			Name:  "dest",
 			Usage: "destination directory in the working container's filesystem",
 		},
+		cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "additional header to send when retrieving URLs e.g. \"Name: value\"",
+		},
+		cli.StringFlag{
+			Name:  "bearer-token",
+			Usage: "bearer token to use when retrieving URLs",
+		},
+		cli.StringFlag{
+			Name:  "creds",
+			Usage: "username[:password] to use for basic authentication when retrieving URLs",
+		},
+		cli.StringFlag{
+			Name:  "ca-file",
+			Usage: "file containing additional CA certificates to trust when retrieving URLs",
+		},
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "don't verify server certificates when retrieving URLs",
+		},
+		cli.StringFlag{
+			Name:  "proxy",
+			Usage: "proxy to use when retrieving URLs",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "time limit for retrieving each URL",
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Usage: "number of times to retry retrieving a URL",
+		},
+		cli.DurationFlag{
+			Name:  "retry-delay",
+			Usage: "time to wait between attempts to retrieve a URL",
+			Value: buildah.DefaultURLRetryDelay,
+		},
 	}
-	copyFlags = addFlags
+	addFlags = append(addAndCopyFlags, []cli.Flag{
+		cli.BoolFlag{
+			Name:  "extract-urls",
+			Usage: "extract contents downloaded from URLs if they look like archives",
+		},
+	}...)
+	copyFlags = append(addAndCopyFlags, []cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "name or ID of an image or working container to copy content from",
+		},
+	}...)
 )
 
+func getURLOptions(c *cli.Context) (buildah.URLOptions, error) {
+	options := buildah.URLOptions{
+		Headers: make(http.Header),
+	}
+	if c.IsSet("header") {
+		for _, headerSpec := range c.StringSlice("header") {
+			header := strings.SplitN(headerSpec, ":", 2)
+			if len(header) != 2 {
+				return options, fmt.Errorf("error parsing header %q: expected \"Name: value\"", headerSpec)
+			}
+			options.Headers.Add(strings.TrimSpace(header[0]), strings.TrimSpace(header[1]))
+		}
+	}
+	if c.IsSet("bearer-token") {
+		options.BearerToken = c.String("bearer-token")
+	}
+	if c.IsSet("creds") {
+		creds := strings.SplitN(c.String("creds"), ":", 2)
+		options.Username = creds[0]
+		if len(creds) > 1 {
+			options.Password = creds[1]
+		}
+	}
+	if c.IsSet("ca-file") {
+		options.CAFile = c.String("ca-file")
+	}
+	if c.IsSet("insecure") {
+		options.Insecure = c.Bool("insecure")
+	}
+	if c.IsSet("proxy") {
+		options.Proxy = c.String("proxy")
+	}
+	if c.IsSet("timeout") {
+		options.Timeout = c.Duration("timeout")
+	}
+	if c.IsSet("retries") {
+		options.Retries = c.Int("retries")
+	}
+	if c.IsSet("retry-delay") {
+		options.RetryDelay = c.Duration("retry-delay")
+	}
+	return options, nil
+}
+
 func addAndCopyCmd(c *cli.Context, extractLocalArchives bool) error {
 	name := ""
 	if c.IsSet("name") {
@@ -48,9 +143,21 @@ func addAndCopyCmd(c *cli.Context, extractLocalArchives bool) error {
 	if c.IsSet("dest") {
 		dest = c.String("dest")
 	}
+	extractURLs := false
+	if c.IsSet("extract-urls") {
+		extractURLs = c.Bool("extract-urls")
+	}
+	from := ""
+	if c.IsSet("from") {
+		from = c.String("from")
+	}
 	if name == "" && root == "" && link == "" {
 		return fmt.Errorf("either --name or --root or --link, or some combination, must be specified")
 	}
+	urlOptions, err := getURLOptions(c)
+	if err != nil {
+		return err
+	}
 
 	store, err := getStore(c)
 	if err != nil {
@@ -62,12 +169,20 @@ func addAndCopyCmd(c *cli.Context, extractLocalArchives bool) error {
 		return fmt.Errorf("error reading build container %q: %v", name, err)
 	}
 
-	err = builder.Add(dest, extractLocalArchives, c.Args()...)
+	if from != "" {
+		err = builder.CopyFrom(from, dest, c.Args()...)
+	} else {
+		options := buildah.AddAndCopyOptions{
+			ExtractURLs: extractURLs,
+			URL:         urlOptions,
+		}
+		err = builder.Add(dest, extractLocalArchives, options, c.Args()...)
+	}
 	if err != nil {
 		return fmt.Errorf("error adding content to container: %v", err)
 	}
 
-	return nil
+	return builder.Save()
 }
 
 func addCmd(c *cli.Context) error {
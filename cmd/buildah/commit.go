@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
 	"github.com/containers/storage/pkg/archive"
 	"github.com/projectatomic/buildah"
 	"github.com/urfave/cli"
@@ -25,16 +30,49 @@ var (
 		},
 		cli.BoolFlag{
 			Name:  "do-not-compress",
-			Usage: "don't compress layers",
+			Usage: "don't compress layers (same as --compression=none)",
 		},
 		cli.StringFlag{
+			Name:  "compression",
+			Usage: "compression to apply to layers: gzip or none",
+			Value: "gzip",
+		},
+		cli.IntFlag{
+			Name:  "compression-level",
+			Usage: "compression level to use, if the compression type supports levels",
+		},
+		cli.IntFlag{
+			Name:  "jobs",
+			Usage: "maximum number of layers to compress at the same time (default: number of CPUs)",
+		},
+		cli.StringSliceFlag{
 			Name:  "output",
-			Usage: "image to create",
+			Usage: "image to create (can be specified more than once, to write the image to several locations)",
 		},
 		cli.StringFlag{
 			Name:  "signature-policy",
 			Usage: "signature policy path",
 		},
+		cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "additional name to give the image, if the destination can hold more than one",
+		},
+		cli.StringFlag{
+			Name:  "sign-by",
+			Usage: "sign the image using a GPG key with the specified fingerprint",
+		},
+		cli.BoolFlag{
+			Name:  "squash",
+			Usage: "produce an image with only one layer",
+		},
+		cli.BoolFlag{
+			Name:  "store-layer",
+			Usage: "store the container's changes in a new layer, and continue working on top of it",
+		},
+		cli.Int64Flag{
+			Name:  "timestamp",
+			Usage: "set the image's creation time, and clamp file modification times, to this many seconds after the epoch (default: $SOURCE_DATE_EPOCH, if set)",
+		},
 	}
 )
 
@@ -51,19 +89,62 @@ func commitCmd(c *cli.Context) error {
 	if c.IsSet("link") {
 		link = c.String("link")
 	}
-	output := ""
+	outputs := []string{}
 	if c.IsSet("output") {
-		output = c.String("output")
+		outputs = c.StringSlice("output")
 	}
 	signaturePolicy := ""
 	if c.IsSet("signature-policy") {
 		signaturePolicy = c.String("signature-policy")
 	}
-	compress := archive.Uncompressed
-	if !c.IsSet("do-not-compress") || !c.Bool("do-not-compress") {
-		compress = archive.Gzip
+	compress := archive.Gzip
+	if c.IsSet("compression") {
+		var err error
+		if compress, err = buildah.ParseCompression(c.String("compression")); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("do-not-compress") && c.Bool("do-not-compress") {
+		compress = archive.Uncompressed
+	}
+	var compressionLevel *int
+	if c.IsSet("compression-level") {
+		level := c.Int("compression-level")
+		compressionLevel = &level
+	}
+	jobs := 0
+	if c.IsSet("jobs") {
+		jobs = c.Int("jobs")
+	}
+	signBy := ""
+	if c.IsSet("sign-by") {
+		signBy = c.String("sign-by")
 	}
-	if output == "" {
+	tags := []string{}
+	if c.IsSet("tag") {
+		tags = c.StringSlice("tag")
+	}
+	squash := false
+	if c.IsSet("squash") {
+		squash = c.Bool("squash")
+	}
+	storeLayer := false
+	if c.IsSet("store-layer") {
+		storeLayer = c.Bool("store-layer")
+	}
+	var timestamp *time.Time
+	if c.IsSet("timestamp") {
+		t := time.Unix(c.Int64("timestamp"), 0).UTC()
+		timestamp = &t
+	} else if epoch, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok && epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing $SOURCE_DATE_EPOCH value %q: %v", epoch, err)
+		}
+		t := time.Unix(seconds, 0).UTC()
+		timestamp = &t
+	}
+	if len(outputs) == 0 {
 		return fmt.Errorf("the --output flag must be specified")
 	}
 	if name == "" && root == "" && link == "" {
@@ -80,19 +161,34 @@ func commitCmd(c *cli.Context) error {
 		return fmt.Errorf("error reading build container %q: %v", name, err)
 	}
 
-	dest, err := transports.ParseImageName(output)
-	if err != nil {
-		return fmt.Errorf("error parsing target image name %q: %v", output, err)
+	dests := []types.ImageReference{}
+	for _, output := range outputs {
+		dest, err := transports.ParseImageName(output)
+		if err != nil {
+			return fmt.Errorf("error parsing target image name %q: %v", output, err)
+		}
+		dests = append(dests, dest)
 	}
 
 	options := buildah.CommitOptions{
 		Compression:         compress,
+		CompressionLevel:    compressionLevel,
+		CompressionJobs:     jobs,
 		SignaturePolicyPath: signaturePolicy,
+		Timestamp:           timestamp,
+		Squash:              squash,
+		StoreLayer:          storeLayer,
+		SignBy:              signBy,
+		AdditionalTags:      tags,
 	}
 	updateConfig(builder, c)
-	err = builder.Commit(dest, options)
+	err = builder.CommitMultiple(dests, options)
 	if err != nil {
-		return fmt.Errorf("error committing container to %q: %v", output, err)
+		targets := []string{}
+		for _, output := range outputs {
+			targets = append(targets, strconv.Quote(output))
+		}
+		return fmt.Errorf("error committing container to %s: %v", strings.Join(targets, ", "), err)
 	}
 
 	return nil
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types/container"
 	"github.com/mattn/go-shellwords"
 	"github.com/projectatomic/buildah"
 	"github.com/urfave/cli"
@@ -18,6 +20,10 @@ const (
 
 var (
 	configurationFlags = []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "build-time variable to set for commands run in the container, and for expanding values, e.g. name=value",
+		},
 		cli.StringFlag{
 			Name:  "author",
 			Usage: "image author contact information",
@@ -65,14 +71,70 @@ var (
 		},
 		cli.StringSliceFlag{
 			Name:  "label",
-			Usage: "image configuration label e.g. label=value",
+			Usage: "image configuration label e.g. label=value, or label or label- to remove it",
 		},
 		cli.StringSliceFlag{
 			Name:  "annotation",
-			Usage: "image annotation e.g. annotation=value",
+			Usage: "image annotation e.g. annotation=value, or annotation or annotation- to remove it",
+		},
+		cli.StringSliceFlag{
+			Name:  "unset-env",
+			Usage: "environment variable to remove from the image's configuration",
+		},
+		cli.StringSliceFlag{
+			Name:  "unset-label",
+			Usage: "label to remove from the image's configuration",
+		},
+		cli.StringSliceFlag{
+			Name:  "unset-volume",
+			Usage: "volume to remove from the image's configuration",
+		},
+		cli.StringSliceFlag{
+			Name:  "unset-port",
+			Usage: "exposed port to remove from the image's configuration",
+		},
+		cli.BoolFlag{
+			Name:  "clear-cmd",
+			Usage: "remove the command from the image's configuration",
+		},
+		cli.BoolFlag{
+			Name:  "clear-entrypoint",
+			Usage: "remove the entry point from the image's configuration",
+		},
+		cli.StringFlag{
+			Name:  "healthcheck",
+			Usage: "shell command to run to check that containers based on image are healthy, or \"NONE\"",
+		},
+		cli.DurationFlag{
+			Name:  "healthcheck-interval",
+			Usage: "time to wait between health checks",
+		},
+		cli.DurationFlag{
+			Name:  "healthcheck-timeout",
+			Usage: "time to wait for a health check to complete",
+		},
+		cli.IntFlag{
+			Name:  "healthcheck-retries",
+			Usage: "number of consecutive failed health checks before a container is considered unhealthy",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal to use to stop containers based on image",
+		},
+		cli.StringFlag{
+			Name:  "shell",
+			Usage: "shell to use for shell-form commands in containers based on image",
+		},
+		cli.StringSliceFlag{
+			Name:  "onbuild",
+			Usage: "instruction to run when the image is used as the base for another image",
 		},
 	}
 	runConfigurationFlags = []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "build-time variable to set for commands run in the container, and for expanding values, e.g. name=value",
+		},
 		cli.StringFlag{
 			Name:  "user",
 			Usage: "user to run containers based on image as",
@@ -115,8 +177,20 @@ var (
 )
 
 func updateConfig(builder *buildah.Builder, c *cli.Context) {
+	if c.IsSet("build-arg") {
+		for _, argSpec := range c.StringSlice("build-arg") {
+			arg := strings.SplitN(argSpec, "=", 2)
+			if len(arg) > 1 {
+				builder.SetArg(arg[0], arg[1])
+			} else if value, ok := os.LookupEnv(arg[0]); ok {
+				builder.SetArg(arg[0], value)
+			} else {
+				builder.UnsetArg(arg[0])
+			}
+		}
+	}
 	if c.IsSet("author") {
-		builder.Maintainer = c.String("author")
+		builder.SetMaintainer(c.String("author"))
 	}
 	if c.IsSet("created-by") {
 		builder.CreatedBy = c.String("created-by")
@@ -128,57 +202,117 @@ func updateConfig(builder *buildah.Builder, c *cli.Context) {
 		builder.OS = c.String("os")
 	}
 	if c.IsSet("user") {
-		builder.User = c.String("user")
+		builder.SetUser(c.String("user"))
 	}
-	if c.IsSet("port") {
-		if builder.Expose == nil {
-			builder.Expose = make(map[string]interface{})
+	if c.IsSet("unset-port") {
+		for _, portSpec := range c.StringSlice("unset-port") {
+			builder.UnexposePort(portSpec)
 		}
+	}
+	if c.IsSet("port") {
 		for _, portSpec := range c.StringSlice("port") {
-			builder.Expose[portSpec] = struct{}{}
+			builder.ExposePort(portSpec)
+		}
+	}
+	if c.IsSet("unset-env") {
+		for _, name := range c.StringSlice("unset-env") {
+			builder.UnsetEnv(name)
 		}
 	}
 	if c.IsSet("env") {
 		for _, envSpec := range c.StringSlice("env") {
-			builder.Env = append(builder.Env, envSpec)
+			builder.SetEnv(builder.Expand(envSpec))
 		}
 	}
+	if c.IsSet("clear-entrypoint") && c.Bool("clear-entrypoint") {
+		builder.ClearEntrypoint()
+	}
 	if c.IsSet("entrypoint") {
 		entrypointSpec, err := shellwords.Parse(c.String("entrypoint"))
 		if err != nil {
 			logrus.Errorf("error parsing --entrypoint %q: %v", c.String("entrypoint"), err)
 		} else {
-			builder.Entrypoint = entrypointSpec
+			builder.SetEntrypoint(entrypointSpec)
 		}
 	}
+	if c.IsSet("clear-cmd") && c.Bool("clear-cmd") {
+		builder.ClearCmd()
+	}
 	if c.IsSet("cmd") {
 		cmdSpec, err := shellwords.Parse(c.String("cmd"))
 		if err != nil {
 			logrus.Errorf("error parsing --cmd %q: %v", c.String("cmd"), err)
 		} else {
-			builder.Cmd = cmdSpec
+			builder.SetCmd(cmdSpec)
+		}
+	}
+	if c.IsSet("unset-volume") {
+		for _, volSpec := range c.StringSlice("unset-volume") {
+			builder.RemoveVolume(volSpec)
 		}
 	}
 	if c.IsSet("volume") {
 		for _, volSpec := range c.StringSlice("volume") {
-			builder.Volumes = append(builder.Volumes, volSpec)
+			builder.AddVolume(volSpec)
 		}
 	}
-	if c.IsSet("label") {
-		if builder.Labels == nil {
-			builder.Labels = make(map[string]string)
+	if c.IsSet("unset-label") {
+		for _, key := range c.StringSlice("unset-label") {
+			builder.UnsetLabel(key)
 		}
+	}
+	if c.IsSet("label") {
 		for _, labelSpec := range c.StringSlice("label") {
 			label := strings.SplitN(labelSpec, "=", 2)
 			if len(label) > 1 {
-				builder.Labels[label[0]] = label[1]
+				builder.SetLabel(builder.Expand(label[0]), builder.Expand(label[1]))
 			} else {
-				delete(builder.Labels, label[0])
+				builder.UnsetLabel(strings.TrimSuffix(label[0], "-"))
 			}
 		}
 	}
 	if c.IsSet("workingdir") {
-		builder.Workdir = c.String("workingdir")
+		builder.SetWorkDir(builder.Expand(c.String("workingdir")))
+	}
+	if c.IsSet("healthcheck") || c.IsSet("healthcheck-interval") || c.IsSet("healthcheck-timeout") || c.IsSet("healthcheck-retries") {
+		healthcheck := &container.HealthConfig{}
+		if builder.Healthcheck != nil {
+			*healthcheck = *builder.Healthcheck
+		}
+		if c.IsSet("healthcheck") {
+			test := c.String("healthcheck")
+			if test == "NONE" {
+				healthcheck.Test = []string{"NONE"}
+			} else {
+				healthcheck.Test = []string{"CMD-SHELL", test}
+			}
+		}
+		if c.IsSet("healthcheck-interval") {
+			healthcheck.Interval = c.Duration("healthcheck-interval")
+		}
+		if c.IsSet("healthcheck-timeout") {
+			healthcheck.Timeout = c.Duration("healthcheck-timeout")
+		}
+		if c.IsSet("healthcheck-retries") {
+			healthcheck.Retries = c.Int("healthcheck-retries")
+		}
+		builder.SetHealthcheck(healthcheck)
+	}
+	if c.IsSet("stop-signal") {
+		builder.SetStopSignal(c.String("stop-signal"))
+	}
+	if c.IsSet("shell") {
+		shellSpec, err := shellwords.Parse(c.String("shell"))
+		if err != nil {
+			logrus.Errorf("error parsing --shell %q: %v", c.String("shell"), err)
+		} else {
+			builder.SetShell(shellSpec)
+		}
+	}
+	if c.IsSet("onbuild") {
+		for _, onbuildSpec := range c.StringSlice("onbuild") {
+			builder.AddOnBuild(onbuildSpec)
+		}
 	}
 	if c.IsSet("annotation") {
 		if builder.Annotations == nil {
@@ -189,7 +323,7 @@ func updateConfig(builder *buildah.Builder, c *cli.Context) {
 			if len(annotation) > 1 {
 				builder.Annotations[annotation[0]] = annotation[1]
 			} else {
-				delete(builder.Annotations, annotation[0])
+				delete(builder.Annotations, strings.TrimSuffix(annotation[0], "-"))
 			}
 		}
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+var (
+	diffFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "name or ID of the working container",
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory of the working container",
+		},
+		cli.StringFlag{
+			Name:  "link",
+			Usage: "symlink to the root directory of the working container",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "list the changes as a JSON array",
+		},
+	}
+)
+
+func diffCmd(c *cli.Context) error {
+	name := ""
+	if c.IsSet("name") {
+		name = c.String("name")
+	}
+	root := ""
+	if c.IsSet("root") {
+		root = c.String("root")
+	}
+	link := ""
+	if c.IsSet("link") {
+		link = c.String("link")
+	}
+	if name == "" && root == "" && link == "" {
+		return fmt.Errorf("either --name or --root or --link, or some combination, must be specified")
+	}
+	jsonOutput := false
+	if c.IsSet("json") {
+		jsonOutput = c.Bool("json")
+	}
+
+	store, err := getStore(c)
+	if err != nil {
+		return err
+	}
+
+	builder, err := openBuilder(store, name, root, link)
+	if err != nil {
+		return fmt.Errorf("error reading build container %q: %v", name, err)
+	}
+
+	changes, err := builder.Changes(c.Args()...)
+	if err != nil {
+		return fmt.Errorf("error listing changes in container %q: %v", builder.Container, err)
+	}
+	if jsonOutput {
+		pretty, err := json.MarshalIndent(changes, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", pretty)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %12d %s\n", change.Kind, change.Size, change.Path)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/projectatomic/buildah"
+	"github.com/urfave/cli"
+)
+
+var (
+	exportFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "name or ID of the working container",
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory of the working container",
+		},
+		cli.StringFlag{
+			Name:  "link",
+			Usage: "symlink to the root directory of the working container",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "file to write the tarball to, or \"-\" for standard output",
+		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "compression to apply to the tarball: gzip or none",
+			Value: "none",
+		},
+		cli.IntFlag{
+			Name:  "compression-level",
+			Usage: "compression level to use, if the compression type supports levels",
+		},
+	}
+)
+
+func exportCmd(c *cli.Context) error {
+	name := ""
+	if c.IsSet("name") {
+		name = c.String("name")
+	}
+	args := c.Args()
+	if name == "" && len(args) > 0 {
+		name = args[0]
+		args = args.Tail()
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments specified")
+	}
+	root := ""
+	if c.IsSet("root") {
+		root = c.String("root")
+	}
+	link := ""
+	if c.IsSet("link") {
+		link = c.String("link")
+	}
+	if name == "" && root == "" && link == "" {
+		return fmt.Errorf("either a container name or --name or --root or --link, or some combination, must be specified")
+	}
+	output := ""
+	if c.IsSet("output") {
+		output = c.String("output")
+	}
+	if output == "" {
+		return fmt.Errorf("the --output flag must be specified")
+	}
+	compress := archive.Uncompressed
+	if c.IsSet("compression") {
+		var err error
+		if compress, err = buildah.ParseCompression(c.String("compression")); err != nil {
+			return err
+		}
+		if compress != archive.Uncompressed && compress != archive.Gzip {
+			return fmt.Errorf("unsupported --compression value %q: only gzip and none are supported", c.String("compression"))
+		}
+	}
+	var compressionLevel *int
+	if c.IsSet("compression-level") {
+		level := c.Int("compression-level")
+		compressionLevel = &level
+	}
+
+	store, err := getStore(c)
+	if err != nil {
+		return err
+	}
+
+	builder, err := openBuilder(store, name, root, link)
+	if err != nil {
+		return fmt.Errorf("error reading build container %q: %v", name, err)
+	}
+
+	options := buildah.ExportOptions{
+		Compression:      compress,
+		CompressionLevel: compressionLevel,
+	}
+	var w io.WriteCloser = os.Stdout
+	if output != "-" {
+		if w, err = ioutils.NewAtomicFileWriter(output, 0644); err != nil {
+			return fmt.Errorf("error creating %q: %v", output, err)
+		}
+	}
+	if err = builder.Export(w, options); err != nil {
+		if output != "-" {
+			w.Close()
+			os.Remove(output)
+		}
+		return fmt.Errorf("error exporting container %q: %v", builder.Container, err)
+	}
+	if output != "-" {
+		return w.Close()
+	}
+	return nil
+}
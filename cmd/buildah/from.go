@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/projectatomic/buildah"
 	"github.com/urfave/cli"
 )
@@ -24,6 +25,14 @@ var (
 			Name:  "image",
 			Usage: "name of the starting image",
 		},
+		cli.StringFlag{
+			Name:  "rootfs",
+			Usage: "directory to copy into the root filesystem of a container which is not based on an image",
+		},
+		cli.StringFlag{
+			Name:  "tarball",
+			Usage: "tarball to extract into the root filesystem of a container which is not based on an image",
+		},
 		cli.BoolFlag{
 			Name:  "pull",
 			Usage: "pull the image if not present",
@@ -41,6 +50,18 @@ var (
 			Name:  "signature-policy",
 			Usage: "signature policy path",
 		},
+		cli.StringFlag{
+			Name:  "os",
+			Usage: "operating system of the image to select from a manifest list, and of images committed from the container",
+		},
+		cli.StringFlag{
+			Name:  "arch",
+			Usage: "architecture of the image to select from a manifest list, and of images committed from the container",
+		},
+		cli.StringFlag{
+			Name:  "variant",
+			Usage: "architecture variant of the image to select from a manifest list",
+		},
 		cli.BoolFlag{
 			Name:  "mount",
 			Usage: "mount the working container",
@@ -49,13 +70,36 @@ var (
 			Name:  "link",
 			Usage: "name of a symlink to create to the root directory of the container",
 		},
+		cli.BoolFlag{
+			Name:  "list-onbuild",
+			Usage: "list the image's ONBUILD triggers, one per line, after the container name",
+		},
+		cli.BoolFlag{
+			Name:  "run-onbuild",
+			Usage: "run the image's ONBUILD triggers",
+		},
+		cli.StringFlag{
+			Name:  "context",
+			Usage: "directory to read sources for ONBUILD COPY and ADD triggers from",
+			Value: ".",
+		},
 	}
 )
 
 func fromCmd(c *cli.Context) error {
+	rootfs := ""
+	if c.IsSet("rootfs") {
+		rootfs = c.String("rootfs")
+	}
+	tarball := ""
+	if c.IsSet("tarball") {
+		tarball = c.String("tarball")
+	}
 	image := ""
 	if c.IsSet("image") {
 		image = c.String("image")
+	} else if rootfs != "" || tarball != "" {
+		image = buildah.BaseImageFakeName
 	} else {
 		return fmt.Errorf("an image name (or \"scratch\") must be specified")
 	}
@@ -69,7 +113,7 @@ func fromCmd(c *cli.Context) error {
 	}
 	pullAlways := false
 	if c.IsSet("pull-always") {
-		pull = c.Bool("pull-always")
+		pullAlways = c.Bool("pull-always")
 	}
 	name := ""
 	if c.IsSet("name") {
@@ -90,6 +134,30 @@ func fromCmd(c *cli.Context) error {
 	if c.IsSet("signature-policy") {
 		signaturePolicy = c.String("signature-policy")
 	}
+	operatingSystem := ""
+	if c.IsSet("os") {
+		operatingSystem = c.String("os")
+	}
+	arch := ""
+	if c.IsSet("arch") {
+		arch = c.String("arch")
+	}
+	variant := ""
+	if c.IsSet("variant") {
+		variant = c.String("variant")
+	}
+	listOnBuild := false
+	if c.IsSet("list-onbuild") {
+		listOnBuild = c.Bool("list-onbuild")
+	}
+	runOnBuild := false
+	if c.IsSet("run-onbuild") {
+		runOnBuild = c.Bool("run-onbuild")
+	}
+	contextDir := "."
+	if c.IsSet("context") {
+		contextDir = c.String("context")
+	}
 
 	store, err := getStore(c)
 	if err != nil {
@@ -103,8 +171,13 @@ func fromCmd(c *cli.Context) error {
 		PullAlways:          pullAlways,
 		Mount:               mount,
 		Link:                link,
+		RootFS:              rootfs,
+		Tarball:             tarball,
 		Registry:            registry,
 		SignaturePolicyPath: signaturePolicy,
+		OS:                  operatingSystem,
+		Architecture:        arch,
+		Variant:             variant,
 	}
 
 	builder, err := buildah.NewBuilder(store, options)
@@ -112,10 +185,27 @@ func fromCmd(c *cli.Context) error {
 		return err
 	}
 
+	if runOnBuild {
+		onbuildOptions := buildah.OnBuildOptions{
+			ContextDir: contextDir,
+		}
+		if err = builder.RunOnBuild(onbuildOptions); err != nil {
+			if err2 := builder.Delete(); err2 != nil {
+				logrus.Errorf("error deleting build container %q: %v", builder.Container, err2)
+			}
+			return err
+		}
+	}
+
 	fmt.Printf("%s\n", builder.Container)
 	if options.Mount {
 		fmt.Printf("%s\n", builder.MountPoint)
 	}
+	if listOnBuild {
+		for _, trigger := range builder.OnBuildTriggers {
+			fmt.Printf("%s\n", trigger)
+		}
+	}
 
 	return builder.Save()
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/projectatomic/buildah"
+	"github.com/urfave/cli"
+)
+
+const (
+	// historyCreatedByWidth is the width that we truncate CREATED BY
+	// values to, unless we've been asked not to.
+	historyCreatedByWidth = 45
+)
+
+var (
+	historyFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "name or ID of the image",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "don't truncate layer digests and commands",
+		},
+		cli.BoolFlag{
+			Name:  "quiet",
+			Usage: "only list the digests of layers",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Go template to format each step with, or \"json\" to list the steps as a JSON array",
+		},
+	}
+)
+
+func historyCmd(c *cli.Context) error {
+	image := ""
+	if c.IsSet("image") {
+		image = c.String("image")
+	}
+	args := c.Args()
+	if image == "" && len(args) > 0 {
+		image = args[0]
+		args = args.Tail()
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments specified")
+	}
+	if image == "" {
+		return fmt.Errorf("either an image name or --image must be specified")
+	}
+	noTrunc := false
+	if c.IsSet("no-trunc") {
+		noTrunc = c.Bool("no-trunc")
+	}
+	quiet := false
+	if c.IsSet("quiet") {
+		quiet = c.Bool("quiet")
+	}
+	format := ""
+	if c.IsSet("format") {
+		format = c.String("format")
+	}
+
+	store, err := getStore(c)
+	if err != nil {
+		return err
+	}
+
+	history, err := buildah.ImageHistory(store, image)
+	if err != nil {
+		return fmt.Errorf("error reading history of image %q: %v", image, err)
+	}
+	// List the most recent step first.
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	switch {
+	case format == "json":
+		pretty, err := json.MarshalIndent(history, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", pretty)
+	case format != "":
+		tmpl, err := template.New("history").Parse(format)
+		if err != nil {
+			return fmt.Errorf("error parsing format %q: %v", format, err)
+		}
+		for _, entry := range history {
+			if err = tmpl.Execute(os.Stdout, entry); err != nil {
+				return err
+			}
+			fmt.Printf("\n")
+		}
+	case quiet:
+		for _, entry := range history {
+			if entry.Layer != "" {
+				fmt.Printf("%s\n", layerID(entry, noTrunc))
+			}
+		}
+	default:
+		fmt.Printf("%-12s %-20s %-45s %-10s %s\n", "LAYER", "CREATED", "CREATED BY", "SIZE", "COMMENT")
+		for _, entry := range history {
+			created := "<unknown>"
+			if !entry.Created.IsZero() {
+				created = units.HumanDuration(time.Since(entry.Created)) + " ago"
+			}
+			createdBy := strings.Join(strings.Fields(entry.CreatedBy), " ")
+			if !noTrunc && len(createdBy) > historyCreatedByWidth {
+				createdBy = createdBy[:historyCreatedByWidth-3] + "..."
+			}
+			size := "<unknown>"
+			if entry.Size >= 0 {
+				size = units.HumanSize(float64(entry.Size))
+			}
+			fmt.Printf("%-12s %-20s %-45s %-10s %s\n", layerID(entry, noTrunc), created, createdBy, size, entry.Comment)
+		}
+	}
+	return nil
+}
+
+// layerID returns the digest of the layer that a step added, or "<missing>"
+// if it didn't add one, shortened unless noTrunc is set.
+func layerID(entry buildah.HistoryEntry, noTrunc bool) string {
+	if entry.Layer == "" {
+		return "<missing>"
+	}
+	if noTrunc {
+		return entry.Layer.String()
+	}
+	hex := entry.Layer.Hex()
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
@@ -125,6 +125,35 @@ func main() {
 			Flags:       append(commitFlags, configurationFlags...),
 			Action:      commitCmd,
 		},
+		{
+			Name:        "push",
+			Aliases:     []string{"p"},
+			Usage:       "copy an image to another location",
+			Description: "copies an image from local storage to another location",
+			Flags:       pushFlags,
+			Action:      pushCmd,
+		},
+		{
+			Name:        "diff",
+			Usage:       "list the changes made in a working container",
+			Description: "lists files added (A), changed (C), and deleted (D) in a working container, optionally only those under the specified paths",
+			Flags:       diffFlags,
+			Action:      diffCmd,
+		},
+		{
+			Name:        "export",
+			Usage:       "write the contents of a working container to a tarball",
+			Description: "writes the contents of a working container's root filesystem to a tarball",
+			Flags:       exportFlags,
+			Action:      exportCmd,
+		},
+		{
+			Name:        "history",
+			Usage:       "show the history of an image",
+			Description: "lists the steps which were used to build an image, most recent first, with the layers that they added and the layers' sizes",
+			Flags:       historyFlags,
+			Action:      historyCmd,
+		},
 		{
 			Name:        "delete",
 			Aliases:     []string{"d"},
@@ -133,6 +162,7 @@ func main() {
 			Flags:       deleteFlags,
 			Action:      deleteCmd,
 		},
+		manifestCommand,
 	}
 	app.Run(os.Args)
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/transports"
+	"github.com/projectatomic/buildah"
+	"github.com/urfave/cli"
+)
+
+var (
+	manifestListFlag = cli.StringFlag{
+		Name:  "list",
+		Usage: "name of the manifest list",
+	}
+	manifestCreateFlags = []cli.Flag{
+		manifestListFlag,
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "type of list to produce (oci or docker)",
+			Value: buildah.ManifestListFormatOCI,
+		},
+	}
+	manifestAnnotateFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "os",
+			Usage: "override the operating system of the image",
+		},
+		cli.StringFlag{
+			Name:  "arch",
+			Usage: "override the architecture of the image",
+		},
+		cli.StringFlag{
+			Name:  "variant",
+			Usage: "set the architecture variant of the image",
+		},
+		cli.StringFlag{
+			Name:  "os-version",
+			Usage: "set the operating system version of the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "os-features",
+			Usage: "operating system feature required by the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "features",
+			Usage: "CPU feature required by the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "annotation",
+			Usage: "annotation for the image's entry in the list e.g. annotation=value",
+		},
+	}
+	manifestAddFlags = append([]cli.Flag{
+		manifestListFlag,
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "name or ID of the image to add",
+		},
+	}, manifestAnnotateFlags...)
+	manifestAnnotateCmdFlags = append([]cli.Flag{
+		manifestListFlag,
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "name or ID of the image, or the digest of its manifest",
+		},
+	}, manifestAnnotateFlags...)
+	manifestInspectFlags = []cli.Flag{
+		manifestListFlag,
+	}
+	manifestPushFlags = []cli.Flag{
+		manifestListFlag,
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "registry or OCI layout location to write the images and the list to",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "type of list to write (oci or docker), if not the list's own",
+		},
+		cli.StringFlag{
+			Name:  "signature-policy",
+			Usage: "signature policy path",
+		},
+		cli.StringFlag{
+			Name:  "sign-by",
+			Usage: "sign the images and the list using a GPG key with the specified fingerprint",
+		},
+	}
+	manifestCommand = cli.Command{
+		Name:        "manifest",
+		Usage:       "manipulate manifest lists",
+		Description: "creates, modifies, and pushes lists of images built for different platforms",
+		Subcommands: []cli.Command{
+			{
+				Name:        "create",
+				Usage:       "create a manifest list",
+				Description: "creates an empty manifest list",
+				Flags:       manifestCreateFlags,
+				Action:      manifestCreateCmd,
+			},
+			{
+				Name:        "add",
+				Usage:       "add an image to a manifest list",
+				Description: "adds an image from local storage to a manifest list",
+				Flags:       manifestAddFlags,
+				Action:      manifestAddCmd,
+			},
+			{
+				Name:        "annotate",
+				Usage:       "change the platform and annotations of an image in a manifest list",
+				Description: "changes the platform and annotations recorded for an image in a manifest list",
+				Flags:       manifestAnnotateCmdFlags,
+				Action:      manifestAnnotateCmd,
+			},
+			{
+				Name:        "inspect",
+				Usage:       "display the contents of a manifest list",
+				Description: "displays the list that would be written for a manifest list",
+				Flags:       manifestInspectFlags,
+				Action:      manifestInspectCmd,
+			},
+			{
+				Name:        "push",
+				Usage:       "write the images in a manifest list, and the list, to a location",
+				Description: "writes the images in a manifest list, and then the list itself, to a location",
+				Flags:       manifestPushFlags,
+				Action:      manifestPushCmd,
+			},
+		},
+	}
+)
+
+// openManifestList opens the list named by the --list flag.
+func openManifestList(c *cli.Context) (*buildah.ManifestList, error) {
+	name := ""
+	if c.IsSet("list") {
+		name = c.String("list")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("the --list flag must be specified")
+	}
+	store, err := getStore(c)
+	if err != nil {
+		return nil, err
+	}
+	return buildah.OpenManifestList(store, name)
+}
+
+// getManifestAnnotateOptions reads the flags which change an image's entry in
+// a list.
+func getManifestAnnotateOptions(c *cli.Context) (buildah.ManifestAnnotateOptions, error) {
+	options := buildah.ManifestAnnotateOptions{}
+	if c.IsSet("os") {
+		options.OS = c.String("os")
+	}
+	if c.IsSet("arch") {
+		options.Architecture = c.String("arch")
+	}
+	if c.IsSet("variant") {
+		options.Variant = c.String("variant")
+	}
+	if c.IsSet("os-version") {
+		options.OSVersion = c.String("os-version")
+	}
+	if c.IsSet("os-features") {
+		options.OSFeatures = c.StringSlice("os-features")
+	}
+	if c.IsSet("features") {
+		options.Features = c.StringSlice("features")
+	}
+	if c.IsSet("annotation") {
+		options.Annotations = make(map[string]string)
+		for _, annotationSpec := range c.StringSlice("annotation") {
+			annotation := strings.SplitN(annotationSpec, "=", 2)
+			if len(annotation) != 2 {
+				return options, fmt.Errorf("error parsing annotation %q: expected \"annotation=value\"", annotationSpec)
+			}
+			options.Annotations[annotation[0]] = annotation[1]
+		}
+	}
+	return options, nil
+}
+
+func manifestCreateCmd(c *cli.Context) error {
+	name := ""
+	if c.IsSet("list") {
+		name = c.String("list")
+	}
+	if name == "" {
+		return fmt.Errorf("the --list flag must be specified")
+	}
+	format := c.String("format")
+
+	store, err := getStore(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err = buildah.CreateManifestList(store, name, format); err != nil {
+		return fmt.Errorf("error creating manifest list %q: %v", name, err)
+	}
+	return nil
+}
+
+func manifestAddCmd(c *cli.Context) error {
+	image := ""
+	if c.IsSet("image") {
+		image = c.String("image")
+	}
+	if image == "" {
+		return fmt.Errorf("the --image flag must be specified")
+	}
+	options, err := getManifestAnnotateOptions(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := openManifestList(c)
+	if err != nil {
+		return err
+	}
+
+	instance, err := list.AddImage(image)
+	if err != nil {
+		return fmt.Errorf("error adding image %q to manifest list %q: %v", image, list.Name, err)
+	}
+	if err = list.Annotate(string(instance), options); err != nil {
+		return err
+	}
+	if err = list.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", instance)
+	return nil
+}
+
+func manifestAnnotateCmd(c *cli.Context) error {
+	image := ""
+	if c.IsSet("image") {
+		image = c.String("image")
+	}
+	if image == "" {
+		return fmt.Errorf("the --image flag must be specified")
+	}
+	options, err := getManifestAnnotateOptions(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := openManifestList(c)
+	if err != nil {
+		return err
+	}
+
+	if err = list.Annotate(image, options); err != nil {
+		return err
+	}
+	return list.Save()
+}
+
+func manifestInspectCmd(c *cli.Context) error {
+	list, err := openManifestList(c)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := list.Manifest()
+	if err != nil {
+		return fmt.Errorf("error generating manifest list %q: %v", list.Name, err)
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(manifest, &decoded); err != nil {
+		return err
+	}
+	pretty, err := json.MarshalIndent(decoded, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", pretty)
+	return nil
+}
+
+func manifestPushCmd(c *cli.Context) error {
+	output := ""
+	if c.IsSet("output") {
+		output = c.String("output")
+	}
+	if output == "" {
+		return fmt.Errorf("the --output flag must be specified")
+	}
+	options := buildah.ManifestPushOptions{}
+	if c.IsSet("format") {
+		options.Format = c.String("format")
+	}
+	if c.IsSet("signature-policy") {
+		options.SignaturePolicyPath = c.String("signature-policy")
+	}
+	if c.IsSet("sign-by") {
+		options.SignBy = c.String("sign-by")
+	}
+
+	list, err := openManifestList(c)
+	if err != nil {
+		return err
+	}
+
+	dest, err := transports.ParseImageName(output)
+	if err != nil {
+		return fmt.Errorf("error parsing target image name %q: %v", output, err)
+	}
+
+	if err = list.Push(dest, options); err != nil {
+		return fmt.Errorf("error pushing manifest list %q to %q: %v", list.Name, output, err)
+	}
+	return nil
+}
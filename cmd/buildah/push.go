@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/containers/image/transports"
+	"github.com/projectatomic/buildah"
+	"github.com/urfave/cli"
+)
+
+var (
+	pushFlags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "name or ID of the image to push",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "location to write the image to",
+		},
+		cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "additional name to give the image, if the destination can hold more than one",
+		},
+		cli.StringFlag{
+			Name:  "signature-policy",
+			Usage: "signature policy path",
+		},
+		cli.StringFlag{
+			Name:  "sign-by",
+			Usage: "sign the image using a GPG key with the specified fingerprint",
+		},
+	}
+)
+
+func pushCmd(c *cli.Context) error {
+	image := ""
+	if c.IsSet("image") {
+		image = c.String("image")
+	}
+	output := ""
+	if c.IsSet("output") {
+		output = c.String("output")
+	}
+	if image == "" {
+		return fmt.Errorf("the --image flag must be specified")
+	}
+	if output == "" {
+		return fmt.Errorf("the --output flag must be specified")
+	}
+	options := buildah.PushOptions{}
+	if c.IsSet("tag") {
+		options.AdditionalTags = c.StringSlice("tag")
+	}
+	if c.IsSet("signature-policy") {
+		options.SignaturePolicyPath = c.String("signature-policy")
+	}
+	if c.IsSet("sign-by") {
+		options.SignBy = c.String("sign-by")
+	}
+
+	store, err := getStore(c)
+	if err != nil {
+		return err
+	}
+
+	dest, err := transports.ParseImageName(output)
+	if err != nil {
+		return fmt.Errorf("error parsing target image name %q: %v", output, err)
+	}
+
+	if err = buildah.Push(store, image, dest, options); err != nil {
+		return fmt.Errorf("error pushing image %q to %q: %v", image, output, err)
+	}
+	return nil
+}
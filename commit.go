@@ -1,29 +1,99 @@
 package buildah
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/containers/image/copy"
+	"github.com/containers/image/image"
 	"github.com/containers/image/signature"
+	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
 	"github.com/containers/storage/pkg/archive"
+	"github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // CommitOptions can be used to alter how an image is committed.
 type CommitOptions struct {
 	// Compression specifies the type of compression which is applied to
 	// layer blobs.  The default is to not use compression, but
-	// archive.Gzip is recommended.
+	// archive.Gzip is recommended.  OCI image manifests have no way to
+	// describe layers compressed using archive.Bzip2 or archive.Xz.
 	Compression archive.Compression
+	// CompressionLevel is the level of compression to use, if the
+	// compression algorithm supports levels.  The default level is used if
+	// it is not set.
+	CompressionLevel *int
+	// CompressionJobs is the maximum number of layers to compress at the
+	// same time.  If it is not set, the number of CPUs is used.
+	CompressionJobs int
 	// SignaturePolicyPath specifies an override location for the signature
 	// policy which should be used for verifying the new image as it is
 	// being written.  Except in specific circumstances, no value should be
 	// specified, indicating that the shared, system-wide default policy
 	// should be used.
 	SignaturePolicyPath string
+	// Timestamp, if set, is used as the creation time of the image and of
+	// the entries that are added to its history, instead of the current
+	// time.  Modification times in the layers which hold the container's
+	// changes which are later than it are set to it, and their contents
+	// are written in a consistent order, so that committing the same
+	// contents with the same Timestamp produces the same image.  Layers
+	// from the source image are left as they are.  This is typically set
+	// from the value of $SOURCE_DATE_EPOCH.
+	Timestamp *time.Time
+	// Squash tells the Builder to produce an image with a single layer
+	// containing the container's entire root filesystem, instead of one
+	// which adds a layer to the source image's layers.
+	Squash bool
+	// StoreLayer tells the Builder to first move the container's changes
+	// into a new read-only layer in the Store, using StoreLayer(), so that
+	// later commits won't need to recompute that layer.  The layer's blob
+	// is kept, and reused by later commits which use the same compression
+	// settings and Timestamp.
+	StoreLayer bool
+	// SignBy is the fingerprint of a GPG key to use for signing the
+	// image.  Signatures can only be written to destinations which have
+	// a Docker reference, i.e., a name, and which can store signatures.
+	SignBy string
+	// AdditionalTags are additional names to give the image in the
+	// destination, which must be an OCI layout or archive, a
+	// docker-archive tarball, or the local Store.
+	AdditionalTags []string
 }
 
 // Commit writes the contents of the container, along with its updated
-// configuration, to a new image in the specified location.
+// configuration, to a new image in the specified location.  Compression types
+// which can't be described in the type of manifest that we generate are
+// rejected.
 func (b *Builder) Commit(dest types.ImageReference, options CommitOptions) error {
+	return b.CommitMultiple([]types.ImageReference{dest}, options)
+}
+
+// CommitMultiple writes the contents of the container, along with its updated
+// configuration, to a new image in each of the specified locations.  The
+// layer blobs are only generated once, and are then copied to each of the
+// locations in turn, so this is faster than calling Commit() once for each
+// of them, and the image is the same in each location.  AdditionalTags and
+// SignBy apply to every location.
+func (b *Builder) CommitMultiple(dests []types.ImageReference, options CommitOptions) error {
+	if len(dests) == 0 {
+		return fmt.Errorf("no locations to commit the image to")
+	}
+	if _, err := layerMediaType(v1.MediaTypeImageManifest, options.Compression); err != nil {
+		return err
+	}
+	tagged := []types.ImageReference{}
+	for _, dest := range dests {
+		if options.SignBy != "" && dest.DockerReference() == nil {
+			return fmt.Errorf("can't sign image written to %q: no name to sign it for", transports.ImageName(dest))
+		}
+		dest, err := withAdditionalTags(b.store, dest, options.AdditionalTags)
+		if err != nil {
+			return err
+		}
+		tagged = append(tagged, dest)
+	}
 	policy, err := signature.DefaultPolicy(getSystemContext(options.SignaturePolicyPath))
 	if err != nil {
 		return err
@@ -32,10 +102,67 @@ func (b *Builder) Commit(dest types.ImageReference, options CommitOptions) error
 	if err != nil {
 		return err
 	}
-	src, err := b.makeContainerImageRef(options.Compression)
+	defer policyContext.Destroy()
+	if options.StoreLayer {
+		if err = b.StoreLayer(); err != nil {
+			return err
+		}
+	}
+	ref, err := b.makeContainerImageRef(options)
+	if err != nil {
+		return err
+	}
+	src, err := newSharedImageReference(ref)
 	if err != nil {
 		return err
 	}
-	err = copy.Image(policyContext, dest, src, getCopyOptions())
-	return err
+	defer src.close()
+	copyOptions := getCopyOptions()
+	copyOptions.SignBy = options.SignBy
+	for _, dest := range tagged {
+		if err = copy.Image(policyContext, dest, src, copyOptions); err != nil {
+			if len(tagged) > 1 {
+				return fmt.Errorf("error writing image to %q: %v", transports.ImageName(dest), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sharedImageReference wraps a reference to an image which is expensive to
+// read, so that the image can be copied to more than one location after
+// only reading it once.  Every ImageSource that it opens is the same one,
+// which is only closed when close() is called.
+type sharedImageReference struct {
+	types.ImageReference
+	src types.ImageSource
+}
+
+// sharedImageSource is the ImageSource that sharedImageReference opens.
+type sharedImageSource struct {
+	types.ImageSource
+}
+
+func newSharedImageReference(ref types.ImageReference) (*sharedImageReference, error) {
+	src, err := ref.NewImageSource(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedImageReference{ImageReference: ref, src: src}, nil
+}
+
+func (r *sharedImageReference) NewImageSource(sc *types.SystemContext, manifestTypes []string) (types.ImageSource, error) {
+	return sharedImageSource{ImageSource: r.src}, nil
+}
+
+func (r *sharedImageReference) NewImage(sc *types.SystemContext) (types.Image, error) {
+	return image.FromSource(sharedImageSource{ImageSource: r.src})
+}
+
+func (r *sharedImageReference) close() {
+	r.src.Close()
+}
+
+func (s sharedImageSource) Close() {
 }
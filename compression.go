@@ -0,0 +1,96 @@
+package buildah
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// compressionName returns a human-readable name for a compression algorithm.
+func compressionName(compression archive.Compression) string {
+	switch compression {
+	case archive.Uncompressed:
+		return "none"
+	case archive.Gzip:
+		return "gzip"
+	case archive.Bzip2:
+		return "bzip2"
+	case archive.Xz:
+		return "xz"
+	}
+	return fmt.Sprintf("unknown(%d)", compression)
+}
+
+// ParseCompression converts the name of a compression algorithm ("gzip",
+// "bzip2", "xz", or "none") to the value which is used in CommitOptions.
+func ParseCompression(name string) (archive.Compression, error) {
+	switch name {
+	case "none", "uncompressed":
+		return archive.Uncompressed, nil
+	case "gzip":
+		return archive.Gzip, nil
+	case "bzip2":
+		return archive.Bzip2, nil
+	case "xz":
+		return archive.Xz, nil
+	}
+	return archive.Uncompressed, fmt.Errorf("unrecognized compression type %q", name)
+}
+
+// layerMediaType returns the media type which describes a layer blob that is
+// compressed using the specified algorithm in a manifest of the specified
+// type, or an error if the manifest type has no way to describe such a blob.
+func layerMediaType(manifestType string, compression archive.Compression) (string, error) {
+	switch manifestType {
+	case v1.MediaTypeImageManifest:
+		switch compression {
+		case archive.Uncompressed:
+			return v1.MediaTypeImageLayer, nil
+		case archive.Gzip:
+			return v1.MediaTypeImageLayerGzip, nil
+		}
+	case manifest.DockerV2Schema2MediaType:
+		switch compression {
+		case archive.Gzip:
+			return manifest.DockerV2Schema2LayerMediaType, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported manifest type %q", manifestType)
+	}
+	return "", fmt.Errorf("layers compressed using %s can not be described in manifests of type %q", compressionName(compression), manifestType)
+}
+
+// nopWriteCloser adds a Close() method which does nothing to a Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (n nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressLayer returns a WriteCloser which compresses what is written to it
+// using the specified algorithm, at the specified level if one is set, and
+// writes the result to w.  Closing it flushes any buffered data, but does not
+// close w.
+func compressLayer(w io.Writer, compression archive.Compression, level *int) (io.WriteCloser, error) {
+	switch compression {
+	case archive.Uncompressed:
+		return nopWriteCloser{w}, nil
+	case archive.Gzip:
+		gzipLevel := gzip.DefaultCompression
+		if level != nil {
+			gzipLevel = *level
+		}
+		gz, err := gzip.NewWriterLevel(w, gzipLevel)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up gzip compression at level %d: %v", gzipLevel, err)
+		}
+		return gz, nil
+	}
+	return nil, fmt.Errorf("compressing layers using %s is not supported", compressionName(compression))
+}
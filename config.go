@@ -3,13 +3,54 @@ package buildah
 import (
 	"encoding/json"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types/container"
 	docker "github.com/docker/docker/image"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// dockerConfigExtensions holds the fields of a Docker-format image
+// configuration which the OCI format doesn't define.  We store them alongside
+// the OCI fields, using the same names that Docker uses, so that Docker will
+// pick them up.
+type dockerConfigExtensions struct {
+	Healthcheck *container.HealthConfig `json:"Healthcheck,omitempty"`
+	OnBuild     []string                `json:"OnBuild,omitempty"`
+	StopSignal  string                  `json:"StopSignal,omitempty"`
+	Shell       []string                `json:"Shell,omitempty"`
+}
+
+// extendedImageConfig is an OCI image's runtime configuration, along with
+// Docker-format fields which the OCI format doesn't define.
+type extendedImageConfig struct {
+	ociv1.ImageConfig
+	dockerConfigExtensions
+}
+
+// extendedImage is an OCI image configuration which also carries
+// Docker-format fields which the OCI format doesn't define.
+type extendedImage struct {
+	ociv1.Image
+	Config extendedImageConfig `json:"config,omitempty"`
+}
+
+// readDockerConfigExtensions reads the Docker-format fields which the OCI
+// format doesn't define from an image configuration in either format.
+func readDockerConfigExtensions(config []byte) dockerConfigExtensions {
+	image := struct {
+		Config dockerConfigExtensions `json:"config"`
+	}{}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &image); err != nil {
+			return dockerConfigExtensions{}
+		}
+	}
+	return image.Config
+}
+
 func copyDockerImageConfig(dimage *docker.Image) (ociv1.Image, error) {
 	image := ociv1.Image{
 		Created:      dimage.Created.UTC(),
@@ -93,7 +134,15 @@ func (b *Builder) updatedConfig() []byte {
 	if b.User != "" {
 		image.Config.User = b.User
 	}
+	if len(b.RemovedVolumes) > 0 {
+		for _, volSpec := range b.RemovedVolumes {
+			delete(image.Config.Volumes, volSpec)
+		}
+	}
 	if len(b.Volumes) > 0 {
+		if image.Config.Volumes == nil {
+			image.Config.Volumes = make(map[string]struct{})
+		}
 		for _, volSpec := range b.Volumes {
 			image.Config.Volumes[volSpec] = struct{}{}
 		}
@@ -101,16 +150,30 @@ func (b *Builder) updatedConfig() []byte {
 	if b.Workdir != "" {
 		image.Config.WorkingDir = b.Workdir
 	}
+	if len(b.RemovedEnv) > 0 {
+		for _, name := range b.RemovedEnv {
+			image.Config.Env = unsetEnv(image.Config.Env, name)
+		}
+	}
 	if len(b.Env) > 0 {
 		for _, envSpec := range b.Env {
-			image.Config.Env = append(image.Config.Env, envSpec)
+			image.Config.Env = setEnv(image.Config.Env, envSpec)
 		}
 	}
 	if len(b.Cmd) > 0 {
 		image.Config.Cmd = b.Cmd
+	} else if b.CmdCleared {
+		image.Config.Cmd = nil
 	}
 	if len(b.Entrypoint) > 0 {
 		image.Config.Entrypoint = b.Entrypoint
+	} else if b.EntrypointCleared {
+		image.Config.Entrypoint = nil
+	}
+	if len(b.RemovedPorts) > 0 {
+		for _, port := range b.RemovedPorts {
+			delete(image.Config.ExposedPorts, port)
+		}
 	}
 	if len(b.Expose) > 0 {
 		if image.Config.ExposedPorts == nil {
@@ -120,6 +183,11 @@ func (b *Builder) updatedConfig() []byte {
 			image.Config.ExposedPorts[k] = struct{}{}
 		}
 	}
+	if len(b.RemovedLabels) > 0 {
+		for _, k := range b.RemovedLabels {
+			delete(image.Config.Labels, k)
+		}
+	}
 	if len(b.Labels) > 0 {
 		if image.Config.Labels == nil {
 			image.Config.Labels = make(map[string]string)
@@ -128,10 +196,181 @@ func (b *Builder) updatedConfig() []byte {
 			image.Config.Labels[k] = v
 		}
 	}
-	updatedImageConfig, err := json.Marshal(&image)
+	extensions := readDockerConfigExtensions(b.Config)
+	if b.Healthcheck != nil {
+		extensions.Healthcheck = b.Healthcheck
+	}
+	if b.StopSignal != "" {
+		extensions.StopSignal = b.StopSignal
+	}
+	if len(b.Shell) > 0 {
+		extensions.Shell = b.Shell
+	}
+	// Triggers are consumed by images which are built from this one, so
+	// we don't pass along the ones that came with our source image.
+	extensions.OnBuild = b.OnBuild
+	eimage := extendedImage{
+		Image: image,
+		Config: extendedImageConfig{
+			ImageConfig:            image.Config,
+			dockerConfigExtensions: extensions,
+		},
+	}
+	updatedImageConfig, err := json.Marshal(&eimage)
 	if err != nil {
 		logrus.Errorf("error exporting updated image configuration, using original configuration")
 		return b.Config
 	}
 	return updatedImageConfig
 }
+
+// envName returns the name part of a NAME=VALUE environment variable
+// specification.
+func envName(envSpec string) string {
+	return strings.SplitN(envSpec, "=", 2)[0]
+}
+
+// setEnv returns a copy of the list of NAME=VALUE environment variables with
+// the variable named in envSpec set to its new value, replacing any previous
+// setting for it.
+func setEnv(env []string, envSpec string) []string {
+	name := envName(envSpec)
+	updated := []string{}
+	replaced := false
+	for _, e := range env {
+		if envName(e) == name {
+			if !replaced {
+				updated = append(updated, envSpec)
+				replaced = true
+			}
+			continue
+		}
+		updated = append(updated, e)
+	}
+	if !replaced {
+		updated = append(updated, envSpec)
+	}
+	return updated
+}
+
+// unsetEnv returns a copy of the list of NAME=VALUE environment variables
+// without any settings for the named variable.
+func unsetEnv(env []string, name string) []string {
+	updated := []string{}
+	for _, e := range env {
+		if envName(e) != name {
+			updated = append(updated, e)
+		}
+	}
+	return updated
+}
+
+// removeString returns a copy of the list without any instances of s.
+func removeString(list []string, s string) []string {
+	updated := []string{}
+	for _, l := range list {
+		if l != s {
+			updated = append(updated, l)
+		}
+	}
+	return updated
+}
+
+// SetEnv sets an environment variable, in the form NAME=VALUE, replacing any
+// previous value for it in either the Builder or the source image.
+func (b *Builder) SetEnv(envSpec string) {
+	b.Env = setEnv(b.Env, envSpec)
+	b.RemovedEnv = removeString(b.RemovedEnv, envName(envSpec))
+	b.AddHistory("ENV "+envSpec, true)
+}
+
+// UnsetEnv removes an environment variable from the Builder's settings and
+// from the source image's configuration.
+func (b *Builder) UnsetEnv(name string) {
+	b.Env = unsetEnv(b.Env, name)
+	b.RemovedEnv = append(removeString(b.RemovedEnv, name), name)
+	b.AddHistory("UNSET ENV "+name, true)
+}
+
+// SetLabel sets a label, replacing any previous value for it.
+func (b *Builder) SetLabel(key, value string) {
+	if b.Labels == nil {
+		b.Labels = make(map[string]string)
+	}
+	b.Labels[key] = value
+	b.RemovedLabels = removeString(b.RemovedLabels, key)
+	b.AddHistory("LABEL "+key+"="+value, true)
+}
+
+// UnsetLabel removes a label from the Builder's settings and from the source
+// image's configuration.
+func (b *Builder) UnsetLabel(key string) {
+	delete(b.Labels, key)
+	b.RemovedLabels = append(removeString(b.RemovedLabels, key), key)
+	b.AddHistory("UNSET LABEL "+key, true)
+}
+
+// AddVolume adds a volume to the list of volumes created for containers
+// based on the image.
+func (b *Builder) AddVolume(volSpec string) {
+	b.Volumes = append(removeString(b.Volumes, volSpec), volSpec)
+	b.RemovedVolumes = removeString(b.RemovedVolumes, volSpec)
+	b.AddHistory("VOLUME "+volSpec, true)
+}
+
+// RemoveVolume removes a volume from the Builder's settings and from the
+// source image's configuration.
+func (b *Builder) RemoveVolume(volSpec string) {
+	b.Volumes = removeString(b.Volumes, volSpec)
+	b.RemovedVolumes = append(removeString(b.RemovedVolumes, volSpec), volSpec)
+	b.AddHistory("UNSET VOLUME "+volSpec, true)
+}
+
+// ExposePort adds a port to the list of ports exposed by containers based on
+// the image.
+func (b *Builder) ExposePort(portSpec string) {
+	if b.Expose == nil {
+		b.Expose = make(map[string]interface{})
+	}
+	b.Expose[portSpec] = struct{}{}
+	b.RemovedPorts = removeString(b.RemovedPorts, portSpec)
+	b.AddHistory("EXPOSE "+portSpec, true)
+}
+
+// UnexposePort removes a port from the Builder's settings and from the source
+// image's configuration.
+func (b *Builder) UnexposePort(portSpec string) {
+	delete(b.Expose, portSpec)
+	b.RemovedPorts = append(removeString(b.RemovedPorts, portSpec), portSpec)
+	b.AddHistory("UNSET EXPOSE "+portSpec, true)
+}
+
+// SetCmd sets the default command for containers based on the image.
+func (b *Builder) SetCmd(cmd []string) {
+	b.Cmd = cmd
+	b.CmdCleared = false
+	b.AddHistory("CMD "+jsonForm(cmd), true)
+}
+
+// ClearCmd discards the default command, including any that was set in the
+// source image.
+func (b *Builder) ClearCmd() {
+	b.Cmd = []string{}
+	b.CmdCleared = true
+	b.AddHistory("CMD []", true)
+}
+
+// SetEntrypoint sets the entry point for containers based on the image.
+func (b *Builder) SetEntrypoint(entrypoint []string) {
+	b.Entrypoint = entrypoint
+	b.EntrypointCleared = false
+	b.AddHistory("ENTRYPOINT "+jsonForm(entrypoint), true)
+}
+
+// ClearEntrypoint discards the entry point, including any that was set in the
+// source image.
+func (b *Builder) ClearEntrypoint() {
+	b.Entrypoint = []string{}
+	b.EntrypointCleared = true
+	b.AddHistory("ENTRYPOINT []", true)
+}
@@ -0,0 +1,231 @@
+package buildah
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// sparseBlockSize is the size of the chunks which we check for zeroes
+	// when copying a file which has holes in it.
+	sparseBlockSize = 32 * 1024
+)
+
+// fileID identifies a file by device and inode number, so that we can tell
+// when we've already copied a file through another of its hard links.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// copier copies files and directory trees while preserving ownership,
+// permissions, timestamps, file capabilities and other extended attributes
+// which copiedXattr accepts, device nodes, hard links, and holes in sparse
+// files.
+type copier struct {
+	// links maps files which have more than one link to the location of
+	// the first copy we made of them.
+	links map[fileID]string
+	// dirs is a list of directories whose timestamps we need to set after
+	// we've finished populating them.
+	dirs []dirTimes
+}
+
+type dirTimes struct {
+	path  string
+	atime time.Time
+	mtime time.Time
+}
+
+func newCopier() *copier {
+	return &copier{
+		links: make(map[fileID]string),
+	}
+}
+
+// copyTree copies the contents of the src directory into the dest directory,
+// creating it if it doesn't already exist.
+func (c *copier) copyTree(src, dest string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return c.copyEntry(path, filepath.Join(dest, rel), fi)
+	})
+	if err != nil {
+		return err
+	}
+	return c.finish()
+}
+
+// copyFile copies one item to the destination, following src if it's a
+// symbolic link.
+func (c *copier) copyFile(src, dest string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err = c.copyEntry(src, dest, fi); err != nil {
+		return err
+	}
+	return c.finish()
+}
+
+// finish sets timestamps on directories, now that we've finished adding
+// things to them.
+func (c *copier) finish() error {
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		d := c.dirs[i]
+		if err := os.Chtimes(d.path, d.atime, d.mtime); err != nil {
+			return fmt.Errorf("error setting timestamps on %q: %v", d.path, err)
+		}
+	}
+	c.dirs = nil
+	return nil
+}
+
+// copyEntry copies a single item described by fi from src to dest.
+func (c *copier) copyEntry(src, dest string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("error reading attributes of %q", src)
+	}
+	// Replace anything that's in the way, unless we're merging the
+	// contents of two directories.
+	if existing, err := os.Lstat(dest); err == nil && !(existing.IsDir() && fi.IsDir()) {
+		if err = os.RemoveAll(dest); err != nil {
+			return fmt.Errorf("error removing %q: %v", dest, err)
+		}
+	}
+	if !fi.IsDir() && st.Nlink > 1 {
+		id := fileID{dev: uint64(st.Dev), ino: st.Ino}
+		if first, ok := c.links[id]; ok {
+			if err := os.Link(first, dest); err != nil {
+				return fmt.Errorf("error linking %q to %q: %v", dest, first, err)
+			}
+			return nil
+		}
+		c.links[id] = dest
+	}
+	mode := fi.Mode()
+	switch {
+	case mode.IsDir():
+		if err := os.Mkdir(dest, mode.Perm()); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("error creating directory %q: %v", dest, err)
+		}
+	case mode.IsRegular():
+		if err := copySparse(src, dest, fi, st); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("error reading symbolic link %q: %v", src, err)
+		}
+		if err = os.Symlink(target, dest); err != nil {
+			return fmt.Errorf("error creating symbolic link %q: %v", dest, err)
+		}
+	case mode&(os.ModeDevice|os.ModeNamedPipe) != 0:
+		if err := syscall.Mknod(dest, st.Mode, int(st.Rdev)); err != nil {
+			return fmt.Errorf("error creating device node %q: %v", dest, err)
+		}
+	case mode&os.ModeSocket != 0:
+		// Sockets can't be copied, and aren't useful in images.
+		return nil
+	default:
+		return fmt.Errorf("unsupported file type for %q: %v", src, mode)
+	}
+	if err := os.Lchown(dest, int(st.Uid), int(st.Gid)); err != nil {
+		return fmt.Errorf("error setting ownership of %q: %v", dest, err)
+	}
+	xattrs, err := getXattrs(src)
+	if err != nil {
+		return fmt.Errorf("error reading extended attributes of %q: %v", src, err)
+	}
+	// Set these after changing ownership, since that clears capabilities.
+	if err = setXattrs(dest, xattrs); err != nil {
+		return fmt.Errorf("error setting extended attributes on %q: %v", dest, err)
+	}
+	if mode&os.ModeSymlink != 0 {
+		return nil
+	}
+	// Set the mode after changing ownership, since that can clear the
+	// setuid and setgid bits.
+	if err = os.Chmod(dest, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("error setting permissions on %q: %v", dest, err)
+	}
+	atime := time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	if mode.IsDir() {
+		c.dirs = append(c.dirs, dirTimes{path: dest, atime: atime, mtime: fi.ModTime()})
+		return nil
+	}
+	if err = os.Chtimes(dest, atime, fi.ModTime()); err != nil {
+		return fmt.Errorf("error setting timestamps on %q: %v", dest, err)
+	}
+	return nil
+}
+
+// copySparse copies the contents of a regular file.  If the source file has
+// holes in it, blocks of zeroes are skipped over instead of being written, so
+// that the copy has holes, too.
+func copySparse(src, dest string, fi os.FileInfo, st *syscall.Stat_t) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating %q: %v", dest, err)
+	}
+	defer out.Close()
+	sparse := st.Blocks*512 < fi.Size()
+	if !sparse {
+		if _, err = io.Copy(out, in); err != nil {
+			return fmt.Errorf("error copying %q to %q: %v", src, dest, err)
+		}
+		return nil
+	}
+	buf := make([]byte, sparseBlockSize)
+	zeroes := make([]byte, sparseBlockSize)
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeroes[:n]) {
+				if _, err2 := out.Seek(int64(n), io.SeekCurrent); err2 != nil {
+					return fmt.Errorf("error seeking in %q: %v", dest, err2)
+				}
+			} else if _, err2 := out.Write(buf[:n]); err2 != nil {
+				return fmt.Errorf("error writing to %q: %v", dest, err2)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %q: %v", src, err)
+		}
+	}
+	// Make sure that a hole at the end of the file is accounted for.
+	if err = out.Truncate(fi.Size()); err != nil {
+		return fmt.Errorf("error setting size of %q: %v", dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package buildah
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	is "github.com/containers/image/storage"
+	"github.com/containers/storage/pkg/mount"
+	"github.com/containers/storage/storage"
+)
+
+const (
+	// maxSymlinks is the number of symbolic links we're willing to follow
+	// while resolving a path in another root filesystem.
+	maxSymlinks = 255
+)
+
+// resolveInRoot resolves a path relative to the root directory, following
+// symbolic links as if root was the root of the filesystem, so that the
+// result is always a location under root.
+func resolveInRoot(root, path string) (string, error) {
+	resolved := ""
+	pending := strings.Split(filepath.Clean(string(os.PathSeparator)+path), string(os.PathSeparator))
+	links := 0
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+		if component == "" || component == "." {
+			continue
+		}
+		if component == ".." {
+			resolved = filepath.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		candidate := filepath.Join(resolved, component)
+		fi, err := os.Lstat(filepath.Join(root, candidate))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// Either it's not a link, or it doesn't exist, in
+			// which case we'll let the caller produce the error.
+			resolved = candidate
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links while resolving %q", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, candidate))
+		if err != nil {
+			return "", fmt.Errorf("error reading symbolic link %q: %v", candidate, err)
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		pending = append(strings.Split(target, string(os.PathSeparator)), pending...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// mountReadOnly bind-mounts a directory read-only at a new temporary
+// location, and returns that location along with a function which should be
+// called to unmount and remove it when we're done with it.
+func mountReadOnly(dir string) (string, func(), error) {
+	path, err := ioutil.TempDir(os.TempDir(), Package)
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() {
+		if err2 := os.Remove(path); err2 != nil {
+			logrus.Errorf("error removing %q: %v", path, err2)
+		}
+	}
+	if err = mount.Mount(dir, path, "bind", "bind,ro"); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("error mounting %q read-only at %q: %v", dir, path, err)
+	}
+	return path, func() {
+		if err2 := mount.Unmount(path); err2 != nil {
+			logrus.Errorf("error unmounting %q: %v", path, err2)
+			return
+		}
+		remove()
+	}, nil
+}
+
+// mountCopySource mounts the root filesystem of the named working container
+// or image, and returns a read-only view of it along with a function which
+// should be called to unmount it when we're done with it.  If the container
+// is already mounted, the Store hands us its existing mountpoint, so we only
+// ever read from it through a read-only bind mount.  Images are mounted
+// using a temporary container, so that their layers, which other images can
+// share, are never mounted where they could be written to.
+func (b *Builder) mountCopySource(from string) (string, func(), error) {
+	mountPoint, release, err := b.mountCopySourceContainer(from)
+	if err != nil {
+		return "", nil, err
+	}
+	readOnly, unmount, err := mountReadOnly(mountPoint)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	return readOnly, func() {
+		unmount()
+		release()
+	}, nil
+}
+
+// mountCopySourceContainer mounts the named working container, or a temporary
+// container based on the named image, and returns its location along with a
+// function which should be called to unmount it, and to remove the temporary
+// container if there is one, when we're done with it.
+func (b *Builder) mountCopySourceContainer(from string) (string, func(), error) {
+	if container, err := b.store.GetContainer(from); err == nil {
+		mountPoint, err := b.store.Mount(container.ID, "")
+		if err != nil {
+			return "", nil, fmt.Errorf("error mounting %q: %v", from, err)
+		}
+		return mountPoint, func() {
+			if err2 := b.store.Unmount(container.ID); err2 != nil {
+				logrus.Errorf("error unmounting %q: %v", from, err2)
+			}
+		}, nil
+	}
+	img, err := b.store.GetImage(from)
+	if err != nil {
+		ref, err := is.Transport.ParseStoreReference(b.store, from)
+		if err != nil {
+			return "", nil, fmt.Errorf("error parsing reference to image %q: %v", from, err)
+		}
+		if img, err = is.Transport.GetStoreImage(b.store, ref); err != nil {
+			return "", nil, fmt.Errorf("no such container or image %q: %v", from, err)
+		}
+	}
+	if img.TopLayer == "" {
+		return "", nil, fmt.Errorf("%q has no contents to copy from", from)
+	}
+	container, err := b.store.CreateContainer("", nil, img.ID, "", "", &storage.ContainerOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating temporary container for %q: %v", from, err)
+	}
+	cleanup := func() {
+		if err2 := b.store.DeleteContainer(container.ID); err2 != nil {
+			logrus.Errorf("error deleting temporary container %q: %v", container.ID, err2)
+		}
+	}
+	mountPoint, err := b.store.Mount(container.ID, "")
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("error mounting %q: %v", from, err)
+	}
+	return mountPoint, func() {
+		if err2 := b.store.Unmount(container.ID); err2 != nil {
+			logrus.Errorf("error unmounting %q: %v", from, err2)
+		}
+		cleanup()
+	}, nil
+}
+
+// CopyFrom copies the contents of the named paths in the root filesystem of
+// an image or another working container into the working container's root
+// filesystem, in the way that Add() copies local content.  The source is
+// mounted for the duration of the copy, and unmounted afterward.  The step is
+// recorded in the Builder's history.
+func (b *Builder) CopyFrom(from, destination string, source ...string) error {
+	mountPoint, unmount, err := b.mountCopySource(from)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+	logrus.Debugf("copying from %q, mounted at %q", from, mountPoint)
+	sources := []string{}
+	for _, src := range source {
+		resolved, err := resolveInRoot(mountPoint, src)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, resolved)
+	}
+	if err = b.add(destination, false, AddAndCopyOptions{}, sources...); err != nil {
+		return err
+	}
+	b.AddHistory(fmt.Sprintf("COPY --from=%s %s %s", from, strings.Join(source, " "), destination), false)
+	return nil
+}
@@ -3,6 +3,8 @@ package buildah
 import (
 	"fmt"
 	"os"
+
+	"github.com/Sirupsen/logrus"
 )
 
 // Delete removes the working container.  The Builder object should not be used
@@ -18,6 +20,14 @@ func (b *Builder) Delete() error {
 	if err := b.store.DeleteContainer(b.ContainerID); err != nil {
 		return fmt.Errorf("error deleting build container: %v", err)
 	}
+	// Remove the images which StoreLayer() created, newest first, so
+	// that the layers which only they were using are removed, too.
+	for i := len(b.IntermediateImages) - 1; i >= 0; i-- {
+		if _, err := b.store.DeleteImage(b.IntermediateImages[i], true); err != nil {
+			logrus.Errorf("error deleting intermediate image %q: %v", b.IntermediateImages[i], err)
+		}
+	}
+	b.IntermediateImages = nil
 	b.MountPoint = ""
 	b.Container = ""
 	b.ContainerID = ""
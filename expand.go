@@ -0,0 +1,119 @@
+package buildah
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// isNameChar returns true if c can be part of a variable name.
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// expandVariables replaces references to variables in value, in the forms
+// $NAME, ${NAME}, ${NAME:-default}, and ${NAME:+alternate}, using lookup to
+// find their values.  A backslash prevents a dollar sign from being treated
+// as the start of a reference.  Variables which are not set expand to empty
+// strings.
+func expandVariables(value string, lookup func(string) (string, bool)) string {
+	var expanded bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '\\' && i+1 < len(value) && value[i+1] == '$' {
+			expanded.WriteByte('$')
+			i++
+			continue
+		}
+		if c != '$' || i+1 >= len(value) {
+			expanded.WriteByte(c)
+			continue
+		}
+		if value[i+1] == '{' {
+			end := strings.IndexByte(value[i+2:], '}')
+			if end == -1 {
+				expanded.WriteString(value[i:])
+				break
+			}
+			expanded.WriteString(expandReference(value[i+2:i+2+end], lookup))
+			i += 2 + end
+			continue
+		}
+		j := i + 1
+		for j < len(value) && isNameChar(value[j], j == i+1) {
+			j++
+		}
+		if j == i+1 {
+			expanded.WriteByte(c)
+			continue
+		}
+		if v, ok := lookup(value[i+1 : j]); ok {
+			expanded.WriteString(v)
+		}
+		i = j - 1
+	}
+	return expanded.String()
+}
+
+// expandReference expands the contents of a ${...} reference.
+func expandReference(reference string, lookup func(string) (string, bool)) string {
+	if i := strings.Index(reference, ":-"); i != -1 {
+		if v, ok := lookup(reference[:i]); ok && v != "" {
+			return v
+		}
+		return expandVariables(reference[i+2:], lookup)
+	}
+	if i := strings.Index(reference, ":+"); i != -1 {
+		if v, ok := lookup(reference[:i]); ok && v != "" {
+			return expandVariables(reference[i+2:], lookup)
+		}
+		return ""
+	}
+	v, _ := lookup(reference)
+	return v
+}
+
+// SetArg sets a build-time variable.  Build-time variables are visible to
+// commands started by Run() and can be referenced in values passed to
+// Expand(), but are not saved in images.
+func (b *Builder) SetArg(name, value string) {
+	if b.Arg == nil {
+		b.Arg = make(map[string]string)
+	}
+	b.Arg[name] = value
+}
+
+// UnsetArg removes a build-time variable.
+func (b *Builder) UnsetArg(name string) {
+	delete(b.Arg, name)
+}
+
+// Expand replaces references to variables in value, in the forms $NAME,
+// ${NAME}, ${NAME:-default}, and ${NAME:+alternate}, in the way that Docker
+// does for instructions like WORKDIR, ENV, and LABEL.  Environment variables
+// which will be set in the image take precedence over build-time variables.
+func (b *Builder) Expand(value string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	image := ociv1.Image{}
+	if err := json.Unmarshal(b.updatedConfig(), &image); err != nil {
+		image.Config.Env = b.Env
+	}
+	lookup := func(name string) (string, bool) {
+		for i := len(image.Config.Env) - 1; i >= 0; i-- {
+			env := strings.SplitN(image.Config.Env[i], "=", 2)
+			if len(env) > 1 && env[0] == name {
+				return env[1], true
+			}
+		}
+		v, ok := b.Arg[name]
+		return v, ok
+	}
+	return expandVariables(value, lookup)
+}
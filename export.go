@@ -0,0 +1,56 @@
+package buildah
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/storage/pkg/archive"
+)
+
+// ExportOptions can be used to alter how a working container's root
+// filesystem is exported.
+type ExportOptions struct {
+	// Compression specifies the type of compression which is applied to
+	// the tarball.  Gzip and Uncompressed are supported.
+	Compression archive.Compression
+	// CompressionLevel is the compression level to use, if the
+	// compression type supports levels.  The default level is used if it
+	// is not set.
+	CompressionLevel *int
+}
+
+// Export writes a tarball containing the contents of the working container's
+// root filesystem, including device nodes and extended attributes, to w.
+// The container is mounted while this is being done if it isn't already.
+func (b *Builder) Export(w io.Writer, options ExportOptions) error {
+	mountPoint, err := b.store.Mount(b.ContainerID, "")
+	if err != nil {
+		return fmt.Errorf("error mounting build container %q: %v", b.ContainerID, err)
+	}
+	defer func() {
+		if err2 := b.store.Unmount(b.ContainerID); err2 != nil {
+			logrus.Errorf("error unmounting build container %q: %v", b.ContainerID, err2)
+		}
+	}()
+
+	rc, err := archive.TarWithOptions(mountPoint, &archive.TarOptions{Compression: archive.Uncompressed})
+	if err != nil {
+		return fmt.Errorf("error reading contents of %q: %v", mountPoint, err)
+	}
+	defer rc.Close()
+	// The archive package only records file capabilities, so pick up the
+	// rest of the extended attributes ourselves.
+	tarball := addXattrsToLayer(rc, mountPoint)
+	defer tarball.Close()
+
+	compressor, err := compressLayer(w, options.Compression, options.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(compressor, tarball); err != nil {
+		compressor.Close()
+		return fmt.Errorf("error writing contents of %q: %v", mountPoint, err)
+	}
+	return compressor.Close()
+}
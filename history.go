@@ -0,0 +1,92 @@
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AddHistory records a step in the Builder's history, which is added to the
+// history of the source image when the container is committed.  Steps which
+// only change the image's configuration should set emptyLayer.  The
+// Builder's other methods record their own steps, so this only needs to be
+// called by callers which modify the Builder's fields directly.
+func (b *Builder) AddHistory(createdBy string, emptyLayer bool) {
+	b.History = append(b.History, ociv1.History{
+		Created:    time.Now().UTC(),
+		CreatedBy:  createdBy,
+		EmptyLayer: emptyLayer,
+	})
+}
+
+// jsonForm formats a list of strings as a JSON array, the way that they would
+// appear in an instruction in a Dockerfile.
+func jsonForm(list []string) string {
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Sprintf("%q", list)
+	}
+	return string(encoded)
+}
+
+// SetWorkDir sets the default working directory for commands started in
+// containers based on the image, and for relative destinations passed to
+// Add().
+func (b *Builder) SetWorkDir(workdir string) {
+	b.Workdir = workdir
+	b.AddHistory("WORKDIR "+workdir, true)
+}
+
+// SetUser sets the user as whom commands are run in the container.
+func (b *Builder) SetUser(user string) {
+	b.User = user
+	b.AddHistory("USER "+user, true)
+}
+
+// SetMaintainer sets the point of contact for the image.
+func (b *Builder) SetMaintainer(maintainer string) {
+	b.Maintainer = maintainer
+	b.AddHistory("MAINTAINER "+maintainer, true)
+}
+
+// SetStopSignal sets the signal which should be used to stop containers based
+// on the image.
+func (b *Builder) SetStopSignal(signal string) {
+	b.StopSignal = signal
+	b.AddHistory("STOPSIGNAL "+signal, true)
+}
+
+// SetShell sets the shell which should be used for shell-form commands.
+func (b *Builder) SetShell(shell []string) {
+	b.Shell = shell
+	b.AddHistory("SHELL "+jsonForm(shell), true)
+}
+
+// SetHealthcheck sets how to check that a container based on the image is
+// healthy.
+func (b *Builder) SetHealthcheck(healthcheck *container.HealthConfig) {
+	b.Healthcheck = healthcheck
+	if healthcheck == nil || len(healthcheck.Test) == 0 {
+		b.AddHistory("HEALTHCHECK", true)
+		return
+	}
+	switch healthcheck.Test[0] {
+	case "NONE":
+		b.AddHistory("HEALTHCHECK NONE", true)
+	case "CMD-SHELL":
+		b.AddHistory("HEALTHCHECK CMD "+strings.Join(healthcheck.Test[1:], " "), true)
+	default:
+		b.AddHistory("HEALTHCHECK CMD "+jsonForm(healthcheck.Test[1:]), true)
+	}
+}
+
+// AddOnBuild adds an instruction to the list of instructions which will be run
+// when the image is used as the base for another image.
+func (b *Builder) AddOnBuild(instruction string) {
+	b.OnBuild = append(b.OnBuild, instruction)
+	b.AddHistory("ONBUILD "+instruction, true)
+}
@@ -8,11 +8,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
 	is "github.com/containers/image/storage"
 	"github.com/containers/image/types"
 	"github.com/containers/storage/pkg/archive"
@@ -26,11 +29,26 @@ import (
 type containerImageRef struct {
 	store       storage.Store
 	container   *storage.Container
+	layerID     string
 	compression archive.Compression
+	level       *int
 	name        reference.Named
 	config      []byte
 	createdBy   string
+	history     []v1.History
+	committed   int
 	annotations map[string]string
+	timestamp   *time.Time
+	squash      bool
+	jobs        int
+	// ownLayers are the IDs of the layers which hold changes that were
+	// made in the container, as opposed to ones from its source image.
+	ownLayers map[string]bool
+	// storedLayers are the IDs of the read-only layers which StoreLayer()
+	// created, whose blobs we keep in cacheDir so that later commits can
+	// reuse them.
+	storedLayers map[string]bool
+	cacheDir     string
 }
 
 type containerImageSource struct {
@@ -42,6 +60,9 @@ type containerImageSource struct {
 	config       []byte
 	configDigest digest.Digest
 	manifest     []byte
+	// blobs are the locations of layer blobs which we didn't write to
+	// path, keyed by digest.
+	blobs map[digest.Digest]string
 }
 
 func (i *containerImageRef) NewImage(sc *types.SystemContext) (types.Image, error) {
@@ -54,19 +75,30 @@ func (i *containerImageRef) NewImage(sc *types.SystemContext) (types.Image, erro
 
 func (i *containerImageRef) NewImageSource(sc *types.SystemContext, manifestTypes []string) (src types.ImageSource, err error) {
 	if len(manifestTypes) > 0 {
-		ok := false
+		// We can only generate OCI manifests, but the image can be
+		// converted to use a Docker v2 schema 2 manifest when it's
+		// copied, so that will do, too, so long as its layers can be
+		// described in one.
+		ociOK, dockerOK := false, false
 		for _, mt := range manifestTypes {
-			if mt == v1.MediaTypeImageManifest {
-				ok = true
-				break
+			switch mt {
+			case v1.MediaTypeImageManifest:
+				ociOK = true
+			case manifest.DockerV2Schema2MediaType:
+				dockerOK = true
 			}
 		}
-		if !ok {
+		if !ociOK && !dockerOK {
 			return nil, fmt.Errorf("no supported manifest types")
 		}
+		if !ociOK {
+			if _, err = layerMediaType(manifest.DockerV2Schema2MediaType, i.compression); err != nil {
+				return nil, err
+			}
+		}
 	}
 	layers := []string{}
-	layerID := i.container.LayerID
+	layerID := i.layerID
 	layer, err := i.store.GetLayer(layerID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read layer %q: %v", layerID, err)
@@ -84,8 +116,16 @@ func (i *containerImageRef) NewImageSource(sc *types.SystemContext, manifestType
 		}
 	}
 	logrus.Debugf("layer list: %q", layers)
+	if i.squash {
+		// Mounting the topmost layer gets us the contents of all of
+		// them, so we only need to look at that one.
+		layers = []string{i.layerID}
+	}
 
 	created := time.Now().UTC()
+	if i.timestamp != nil {
+		created = i.timestamp.UTC()
+	}
 
 	path, err := ioutil.TempDir(os.TempDir(), Package)
 	if err != nil {
@@ -101,11 +141,14 @@ func (i *containerImageRef) NewImageSource(sc *types.SystemContext, manifestType
 		}
 	}()
 
-	image := v1.Image{}
+	image := extendedImage{}
 	err = json.Unmarshal(i.config, &image)
 	if err != nil {
 		return nil, err
 	}
+	if i.timestamp != nil {
+		image.Created = created
+	}
 
 	manifest := v1.Manifest{
 		Versioned: specs.Versioned{
@@ -120,79 +163,80 @@ func (i *containerImageRef) NewImageSource(sc *types.SystemContext, manifestType
 
 	image.RootFS.Type = "layers"
 	image.RootFS.DiffIDs = []string{}
+	if i.squash {
+		// None of the entries in the source image's history
+		// correspond to layers in this image any more.
+		for j := range image.History {
+			image.History[j].EmptyLayer = true
+		}
+	}
 	lastLayerDiffID := ""
 
-	for _, layerID := range layers {
-		rc, err := i.store.Diff("", layerID)
-		if err != nil {
-			return nil, fmt.Errorf("error extracting layer %q: %v", layerID, err)
-		}
-		defer rc.Close()
-		uncompressed, err := archive.DecompressStream(rc)
-		if err != nil {
-			return nil, fmt.Errorf("error decompressing layer %q: %v", layerID, err)
-		}
-		defer uncompressed.Close()
-		srcHasher := digest.Canonical.Digester()
-		reader := io.TeeReader(uncompressed, srcHasher.Hash())
-		layerFile, err := os.OpenFile(filepath.Join(path, "layer"), os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			return nil, fmt.Errorf("error opening file for layer %q: %v", layerID, err)
-		}
-		destHasher := digest.Canonical.Digester()
-		counter := ioutils.NewWriteCounter(layerFile)
-		multiWriter := io.MultiWriter(counter, destHasher.Hash())
-		mediaType := v1.MediaTypeImageLayer
-		if i.compression != archive.Uncompressed {
-			switch i.compression {
-			case archive.Gzip:
-				mediaType = v1.MediaTypeImageLayerGzip
-				logrus.Debugf("compressing layer %q with gzip", layerID)
-			case archive.Bzip2:
-				logrus.Debugf("compressing layer %q with bzip2", layerID)
-			default:
-				logrus.Debugf("compressing layer %q with unknown compressor(?)", layerID)
-			}
-		}
-		compressor, err := archive.CompressStream(multiWriter, i.compression)
-		if err != nil {
-			return nil, fmt.Errorf("error compressing layer %q: %v", layerID, err)
-		}
-		size, err := io.Copy(compressor, reader)
-		if err != nil {
-			return nil, fmt.Errorf("error storing layer %q to file: %v", layerID, err)
-		}
-		compressor.Close()
-		layerFile.Close()
-		if i.compression == archive.Uncompressed {
-			if size != counter.Count {
-				return nil, fmt.Errorf("error storing layer %q to file: inconsistent layer size (copied %d, wrote %d)", layerID, size, counter.Count)
-			}
-		} else {
-			size = counter.Count
+	mediaType, err := layerMediaType(v1.MediaTypeImageManifest, i.compression)
+	if err != nil {
+		return nil, err
+	}
+	// Write the layers' blobs, several at a time.  We need their digests
+	// and sizes for the manifest, so we can't put it off until someone
+	// asks for them, and we keep copies because a diff that we generate
+	// again later isn't guaranteed to be byte-for-byte identical.
+	jobs := i.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	blobs := make([]layerBlob, len(layers))
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for j, layerID := range layers {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(j int, layerID string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			blobs[j] = i.writeLayerBlob(path, layerID, mediaType, created)
+		}(j, layerID)
+	}
+	wg.Wait()
+	cached := make(map[digest.Digest]string)
+	for _, blob := range blobs {
+		if blob.err != nil {
+			return nil, blob.err
 		}
-		logrus.Debugf("layer %q size is %d bytes", layerID, size)
-		err = os.Rename(filepath.Join(path, "layer"), filepath.Join(path, destHasher.Digest().String()))
-		if err != nil {
-			return nil, fmt.Errorf("error storing layer %q to file: %v", layerID, err)
+		if blob.file != "" {
+			cached[blob.descriptor.Digest] = blob.file
 		}
-		layerDescriptor := v1.Descriptor{
-			MediaType: mediaType,
-			Digest:    destHasher.Digest(),
-			Size:      size,
-		}
-		manifest.Layers = append(manifest.Layers, layerDescriptor)
-		lastLayerDiffID = destHasher.Digest().String()
+		manifest.Layers = append(manifest.Layers, blob.descriptor)
+		lastLayerDiffID = blob.diffID.String()
 		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, lastLayerDiffID)
 	}
 
-	news := v1.History{
-		Created:    created,
-		CreatedBy:  i.createdBy,
-		Author:     image.Author,
-		EmptyLayer: false,
+	// Entries for steps whose changes were already stored in layers of
+	// their own are final.  The rest share whichever layer we're adding.
+	committed := i.committed
+	if i.squash {
+		committed = 0
+	}
+	pending, credited := layerHistory(i.history[committed:])
+	for _, step := range append(append([]v1.History{}, i.history[:committed]...), pending...) {
+		if step.Author == "" {
+			step.Author = image.Author
+		}
+		if i.timestamp != nil {
+			step.Created = created
+		}
+		image.History = append(image.History, step)
+	}
+	if !credited && (i.squash || i.layerID == i.container.LayerID) {
+		news := v1.History{
+			Created:    created,
+			CreatedBy:  i.createdBy,
+			Author:     image.Author,
+			EmptyLayer: false,
+		}
+		image.History = append(image.History, news)
 	}
-	image.History = append(image.History, news)
 
 	config, err := json.Marshal(&image)
 	if err != nil {
@@ -219,10 +263,204 @@ func (i *containerImageRef) NewImageSource(sc *types.SystemContext, manifestType
 		manifest:     mfest,
 		config:       i.config,
 		configDigest: digest.FromBytes(config),
+		blobs:        cached,
 	}
 	return src, nil
 }
 
+// layerBlob describes a layer blob that writeLayerBlob() has written.  If
+// the blob was written to the cache directory, file is its location.
+type layerBlob struct {
+	descriptor v1.Descriptor
+	diffID     digest.Digest
+	file       string
+	err        error
+}
+
+// cachedLayerBlob is what we record in the cache directory about a blob
+// that we've kept there.
+type cachedLayerBlob struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+	DiffID digest.Digest `json:"diff-id"`
+}
+
+// layerBlobCacheKey returns the name under which we record the blob that we
+// generate for a stored layer with the current compression and timestamp
+// settings in the cache directory.
+func (i *containerImageRef) layerBlobCacheKey(layerID string) string {
+	level := "default"
+	if i.level != nil {
+		level = fmt.Sprintf("%d", *i.level)
+	}
+	timestamp := "none"
+	if i.timestamp != nil {
+		timestamp = i.timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return digest.FromString(fmt.Sprintf("%s %d %s %s", layerID, i.compression, level, timestamp)).Hex() + ".json"
+}
+
+// readCachedLayerBlob returns a description of a blob which we already
+// generated for the layer and kept in the cache directory, if there is one.
+func (i *containerImageRef) readCachedLayerBlob(layerID, mediaType string) (layerBlob, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(i.cacheDir, i.layerBlobCacheKey(layerID)))
+	if err != nil {
+		return layerBlob{}, false
+	}
+	cached := cachedLayerBlob{}
+	if err = json.Unmarshal(data, &cached); err != nil {
+		logrus.Debugf("error parsing cached information about layer %q: %v", layerID, err)
+		return layerBlob{}, false
+	}
+	file := filepath.Join(i.cacheDir, cached.Digest.String())
+	if st, err := os.Stat(file); err != nil || st.Size() != cached.Size {
+		return layerBlob{}, false
+	}
+	return layerBlob{
+		descriptor: v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    cached.Digest,
+			Size:      cached.Size,
+		},
+		diffID: cached.DiffID,
+		file:   file,
+	}, true
+}
+
+// writeLayerBlob writes the blob for a layer to a file in the directory,
+// named after its digest, and returns a description of it.  Blobs for layers
+// which StoreLayer() created are written to the cache directory instead, and
+// are reused if we've already written them.
+func (i *containerImageRef) writeLayerBlob(path, layerID, mediaType string, created time.Time) layerBlob {
+	fail := func(err error) layerBlob {
+		return layerBlob{err: err}
+	}
+	cache := !i.squash && i.cacheDir != "" && i.storedLayers[layerID]
+	if cache {
+		if blob, ok := i.readCachedLayerBlob(layerID, mediaType); ok {
+			logrus.Debugf("reusing blob %q for layer %q", blob.descriptor.Digest.String(), layerID)
+			return blob
+		}
+	}
+	blobDir := path
+	if cache {
+		if err := os.MkdirAll(i.cacheDir, 0700); err != nil {
+			return fail(fmt.Errorf("error creating directory %q: %v", i.cacheDir, err))
+		}
+		blobDir = i.cacheDir
+	}
+	// The diff only records file capabilities, so if the layer holds
+	// changes made in the container, mount it and pick up the rest of the
+	// extended attributes from there, and clamp its timestamps if we were
+	// asked to.  Layers that we got from images are left alone, so that
+	// their digests don't change.
+	annotate := i.squash || i.ownLayers[layerID]
+	mountPoint := ""
+	if annotate {
+		var err error
+		if mountPoint, err = i.store.Mount(layerID, ""); err != nil {
+			return fail(fmt.Errorf("error mounting layer %q: %v", layerID, err))
+		}
+		defer func() {
+			if err2 := i.store.Unmount(layerID); err2 != nil {
+				logrus.Errorf("error unmounting layer %q: %v", layerID, err2)
+			}
+		}()
+	}
+	var uncompressed io.ReadCloser
+	var err error
+	if i.squash {
+		// Archive the entire root filesystem, in which any whiteouts
+		// have already been applied.
+		uncompressed, err = archive.TarWithOptions(mountPoint, &archive.TarOptions{Compression: archive.Uncompressed})
+		if err != nil {
+			return fail(fmt.Errorf("error archiving contents of layer %q: %v", layerID, err))
+		}
+		defer uncompressed.Close()
+	} else {
+		rc, err := i.store.Diff("", layerID)
+		if err != nil {
+			return fail(fmt.Errorf("error extracting layer %q: %v", layerID, err))
+		}
+		defer rc.Close()
+		uncompressed, err = archive.DecompressStream(rc)
+		if err != nil {
+			return fail(fmt.Errorf("error decompressing layer %q: %v", layerID, err))
+		}
+		defer uncompressed.Close()
+	}
+	var contents io.ReadCloser = uncompressed
+	if annotate {
+		contents = addXattrsToLayer(uncompressed, mountPoint)
+		defer contents.Close()
+	}
+	if annotate && i.timestamp != nil {
+		contents, err = clampLayer(contents, path, created)
+		if err != nil {
+			return fail(fmt.Errorf("error normalizing layer %q: %v", layerID, err))
+		}
+		defer contents.Close()
+	}
+	srcHasher := digest.Canonical.Digester()
+	reader := io.TeeReader(contents, srcHasher.Hash())
+	layerFile, err := ioutil.TempFile(blobDir, "layer")
+	if err != nil {
+		return fail(fmt.Errorf("error opening file for layer %q: %v", layerID, err))
+	}
+	defer layerFile.Close()
+	destHasher := digest.Canonical.Digester()
+	counter := ioutils.NewWriteCounter(layerFile)
+	multiWriter := io.MultiWriter(counter, destHasher.Hash())
+	if i.compression != archive.Uncompressed {
+		logrus.Debugf("compressing layer %q with %s", layerID, compressionName(i.compression))
+	}
+	compressor, err := compressLayer(multiWriter, i.compression, i.level)
+	if err != nil {
+		return fail(fmt.Errorf("error compressing layer %q: %v", layerID, err))
+	}
+	size, err := io.Copy(compressor, reader)
+	if err != nil {
+		return fail(fmt.Errorf("error storing layer %q to file: %v", layerID, err))
+	}
+	if err = compressor.Close(); err != nil {
+		return fail(fmt.Errorf("error compressing layer %q: %v", layerID, err))
+	}
+	if i.compression == archive.Uncompressed {
+		if size != counter.Count {
+			return fail(fmt.Errorf("error storing layer %q to file: inconsistent layer size (copied %d, wrote %d)", layerID, size, counter.Count))
+		}
+	} else {
+		size = counter.Count
+	}
+	logrus.Debugf("layer %q size is %d bytes", layerID, size)
+	file := filepath.Join(blobDir, destHasher.Digest().String())
+	err = os.Rename(layerFile.Name(), file)
+	if err != nil {
+		return fail(fmt.Errorf("error storing layer %q to file: %v", layerID, err))
+	}
+	blob := layerBlob{
+		descriptor: v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    destHasher.Digest(),
+			Size:      size,
+		},
+		diffID: srcHasher.Digest(),
+	}
+	if cache {
+		blob.file = file
+		cached, err := json.Marshal(&cachedLayerBlob{Digest: blob.descriptor.Digest, Size: size, DiffID: blob.diffID})
+		if err != nil {
+			return fail(err)
+		}
+		if err = ioutils.AtomicWriteFile(filepath.Join(i.cacheDir, i.layerBlobCacheKey(layerID)), cached, 0600); err != nil {
+			// We can still use the blob, but we'll have to
+			// generate it again next time.
+			logrus.Warnf("error recording blob for layer %q: %v", layerID, err)
+		}
+	}
+	return blob
+}
+
 func (i *containerImageRef) NewImageDestination(sc *types.SystemContext) (types.ImageDestination, error) {
 	return nil, fmt.Errorf("can't write to a container")
 }
@@ -270,8 +508,13 @@ func (i *containerImageSource) GetSignatures() ([][]byte, error) {
 	return nil, nil
 }
 
-func (i *containerImageSource) GetTargetManifest(digest digest.Digest) ([]byte, string, error) {
-	return []byte{}, "", fmt.Errorf("TODO")
+func (i *containerImageSource) GetTargetManifest(d digest.Digest) ([]byte, string, error) {
+	// We only ever produce a single manifest, so it's the only one that a
+	// list could point to.
+	if d == digest.FromBytes(i.manifest) {
+		return i.manifest, v1.MediaTypeImageManifest, nil
+	}
+	return nil, "", fmt.Errorf("no manifest with digest %q", d)
 }
 
 func (i *containerImageSource) GetManifest() ([]byte, string, error) {
@@ -288,7 +531,11 @@ func (i *containerImageSource) GetBlob(blob types.BlobInfo) (reader io.ReadClose
 		}
 		return ioutils.NewReadCloserWrapper(reader, closer), reader.Size(), nil
 	}
-	layerFile, err := os.OpenFile(filepath.Join(i.path, blob.Digest.String()), os.O_RDONLY, 0600)
+	file, ok := i.blobs[blob.Digest]
+	if !ok {
+		file = filepath.Join(i.path, blob.Digest.String())
+	}
+	layerFile, err := os.OpenFile(file, os.O_RDONLY, 0600)
 	if err != nil {
 		logrus.Debugf("error reading layer %q: %v", blob.Digest.String(), err)
 		return nil, -1, err
@@ -309,12 +556,55 @@ func (i *containerImageSource) GetBlob(blob types.BlobInfo) (reader io.ReadClose
 	return ioutils.NewReadCloserWrapper(layerFile, closer), size, nil
 }
 
-func (b *Builder) makeContainerImageRef(compress archive.Compression) (types.ImageReference, error) {
+// layerHistory returns a copy of a list of history entries for steps whose
+// changes to the container's contents all end up in a single layer, in which
+// only the last step which changed the contents is credited with the layer.
+// If none of the steps changed the contents, it returns false.
+func layerHistory(steps []v1.History) ([]v1.History, bool) {
+	last := -1
+	for j, step := range steps {
+		if !step.EmptyLayer {
+			last = j
+		}
+	}
+	history := make([]v1.History, len(steps))
+	for j, step := range steps {
+		step.EmptyLayer = j != last
+		history[j] = step
+	}
+	return history, last != -1
+}
+
+func (b *Builder) makeContainerImageRef(options CommitOptions) (types.ImageReference, error) {
 	var name reference.Named
 	container, err := b.store.GetContainer(b.ContainerID)
 	if err != nil {
 		return nil, err
 	}
+	layerID := container.LayerID
+	if options.StoreLayer {
+		// The container's changes have just been moved to the layer
+		// which is its parent, so its own layer is empty.
+		layer, err := b.store.GetLayer(layerID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read layer %q: %v", layerID, err)
+		}
+		layerID = layer.Parent
+	}
+	ownLayers := map[string]bool{container.LayerID: true}
+	storedLayers := make(map[string]bool)
+	for _, id := range b.IntermediateImages {
+		img, err := b.store.GetImage(id)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read intermediate image %q: %v", id, err)
+		}
+		ownLayers[img.TopLayer] = true
+		storedLayers[img.TopLayer] = true
+	}
+	cdir, err := b.store.GetContainerDirectory(container.ID)
+	if err != nil {
+		return nil, err
+	}
 	if len(container.Names) > 0 {
 		name, err = reference.ParseNamed(container.Names[0])
 		if err != nil {
@@ -322,13 +612,23 @@ func (b *Builder) makeContainerImageRef(compress archive.Compression) (types.Ima
 		}
 	}
 	ref := &containerImageRef{
-		store:       b.store,
-		container:   container,
-		compression: compress,
-		name:        name,
-		config:      b.updatedConfig(),
-		createdBy:   b.CreatedBy,
-		annotations: b.Annotations,
+		store:        b.store,
+		container:    container,
+		layerID:      layerID,
+		compression:  options.Compression,
+		level:        options.CompressionLevel,
+		name:         name,
+		config:       b.updatedConfig(),
+		createdBy:    b.CreatedBy,
+		history:      append([]v1.History{}, b.History...),
+		committed:    b.CommittedHistory,
+		annotations:  b.Annotations,
+		timestamp:    options.Timestamp,
+		squash:       options.Squash,
+		jobs:         options.CompressionJobs,
+		ownLayers:    ownLayers,
+		storedLayers: storedLayers,
+		cacheDir:     filepath.Join(cdir, layerBlobCacheDir),
 	}
 	return ref, nil
 }
@@ -0,0 +1,111 @@
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	is "github.com/containers/image/storage"
+	"github.com/containers/storage/storage"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// HistoryEntry describes a step in the history of an image.
+type HistoryEntry struct {
+	// Created is when the step was performed, if that was recorded.
+	Created time.Time `json:"created"`
+	// CreatedBy is the command or instruction which was used.
+	CreatedBy string `json:"created-by"`
+	// Comment is a comment which was recorded for the step.
+	Comment string `json:"comment,omitempty"`
+	// EmptyLayer is true if the step only changed the image's
+	// configuration, and didn't add a layer.
+	EmptyLayer bool `json:"empty-layer,omitempty"`
+	// Layer is the digest of the uncompressed contents of the layer which
+	// the step added, if it added one.
+	Layer digest.Digest `json:"layer,omitempty"`
+	// Size is the size of the layer which the step added, or -1 if it
+	// couldn't be determined.  It is zero if no layer was added.
+	Size int64 `json:"size"`
+}
+
+// ImageHistory returns the history of an image in the Store, oldest step
+// first, with each step that added a layer matched up with that layer's
+// digest and size.  Layers which don't have steps recorded for them are
+// listed as steps with nothing but a digest and size.
+func ImageHistory(store storage.Store, image string) ([]HistoryEntry, error) {
+	img, err := findImage(store, image)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := is.Transport.ParseStoreReference(store, "@"+img.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reference to image %q: %v", img.ID, err)
+	}
+	src, err := ref.NewImage(getSystemContext(""))
+	if err != nil {
+		return nil, fmt.Errorf("error instantiating image %q: %v", img.ID, err)
+	}
+	defer src.Close()
+	blob, err := src.ConfigBlob()
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration of image %q: %v", img.ID, err)
+	}
+	// The history and list of layers are encoded the same way in Docker
+	// and OCI configurations.
+	config := v1.Image{}
+	if err = json.Unmarshal(blob, &config); err != nil {
+		return nil, fmt.Errorf("error parsing configuration of image %q: %v", img.ID, err)
+	}
+
+	// List the image's layers, starting with the base layer, and look up
+	// their sizes.
+	layers := []string{}
+	for id := img.TopLayer; id != ""; {
+		layer, err := store.GetLayer(id)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read layer %q: %v", id, err)
+		}
+		layers = append([]string{layer.ID}, layers...)
+		id = layer.Parent
+	}
+	diffIDs := config.RootFS.DiffIDs
+	sizes := make([]int64, len(diffIDs))
+	for i := range sizes {
+		sizes[i] = -1
+	}
+	if len(layers) == len(diffIDs) {
+		for i, layer := range layers {
+			if size, err := store.DiffSize("", layer); err == nil {
+				sizes[i] = size
+			} else {
+				logrus.Debugf("error computing size of layer %q: %v", layer, err)
+			}
+		}
+	} else {
+		logrus.Debugf("image %q has %d layers, but its configuration lists %d", img.ID, len(layers), len(diffIDs))
+	}
+
+	history := []HistoryEntry{}
+	next := 0
+	for _, step := range config.History {
+		entry := HistoryEntry{
+			Created:    step.Created,
+			CreatedBy:  step.CreatedBy,
+			Comment:    step.Comment,
+			EmptyLayer: step.EmptyLayer,
+		}
+		if !step.EmptyLayer && next < len(diffIDs) {
+			entry.Layer = digest.Digest(diffIDs[next])
+			entry.Size = sizes[next]
+			next++
+		}
+		history = append(history, entry)
+	}
+	for ; next < len(diffIDs); next++ {
+		history = append(history, HistoryEntry{Layer: digest.Digest(diffIDs[next]), Size: sizes[next]})
+	}
+	return history, nil
+}
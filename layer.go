@@ -0,0 +1,163 @@
+package buildah
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/storage"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layerBlobCacheDir is the name of the directory, in the working container's
+// directory, where we keep the blobs that we generate for stored layers.
+const layerBlobCacheDir = "layer-blobs"
+
+// StoreLayer moves the changes which have been made to the working container's
+// root filesystem into a new read-only layer in the Store, and replaces the
+// container with a new one, with the same name, which is based on that layer,
+// so that later changes are tracked separately.  The new layer is recorded in
+// an unnamed image, which keeps it from being removed until the Builder is
+// deleted.  The blobs which are generated for the layer when images are
+// committed are kept until then, too.  The entries in the Builder's history
+// which describe the changes are finalized.  If the container was mounted, the
+// new container is mounted in its place.
+func (b *Builder) StoreLayer() error {
+	container, err := b.store.GetContainer(b.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error reading build container %q: %v", b.ContainerID, err)
+	}
+	layer, err := b.store.GetLayer(container.LayerID)
+	if err != nil {
+		return fmt.Errorf("unable to read layer %q: %v", container.LayerID, err)
+	}
+	mounted := layer.MountCount > 0
+
+	// The diff only records file capabilities, so mount the layer and
+	// pick up the rest of the extended attributes from there.
+	mountPoint, err := b.store.Mount(container.LayerID, "")
+	if err != nil {
+		return fmt.Errorf("error mounting layer %q: %v", container.LayerID, err)
+	}
+	newLayer, err := func() (*storage.Layer, error) {
+		defer func() {
+			if err2 := b.store.Unmount(container.LayerID); err2 != nil {
+				logrus.Errorf("error unmounting layer %q: %v", container.LayerID, err2)
+			}
+		}()
+		rc, err := b.store.Diff("", container.LayerID)
+		if err != nil {
+			return nil, fmt.Errorf("error extracting layer %q: %v", container.LayerID, err)
+		}
+		defer rc.Close()
+		uncompressed, err := archive.DecompressStream(rc)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing layer %q: %v", container.LayerID, err)
+		}
+		defer uncompressed.Close()
+		diff := addXattrsToLayer(uncompressed, mountPoint)
+		defer diff.Close()
+		newLayer, _, err := b.store.PutLayer("", layer.Parent, nil, "", false, diff)
+		if err != nil {
+			return nil, fmt.Errorf("error storing contents of layer %q: %v", container.LayerID, err)
+		}
+		return newLayer, nil
+	}()
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("stored contents of layer %q as layer %q", container.LayerID, newLayer.ID)
+
+	img, err := b.store.CreateImage("", nil, newLayer.ID, "", nil)
+	if err != nil {
+		if err2 := b.store.DeleteLayer(newLayer.ID); err2 != nil {
+			logrus.Errorf("error deleting layer %q: %v", newLayer.ID, err2)
+		}
+		return fmt.Errorf("error creating image for layer %q: %v", newLayer.ID, err)
+	}
+	newContainer, err := b.store.CreateContainer("", nil, img.ID, "", "", &storage.ContainerOptions{})
+	if err != nil {
+		if _, err2 := b.store.DeleteImage(img.ID, true); err2 != nil {
+			logrus.Errorf("error deleting image %q: %v", img.ID, err2)
+		}
+		return fmt.Errorf("error creating container based on layer %q: %v", newLayer.ID, err)
+	}
+
+	// Save our state to the new container's directory and give it the
+	// old container's names before we remove the old container, so that
+	// if anything goes wrong, we still have a working container.
+	oldState := *b
+	oldState.History = append([]v1.History{}, b.History...)
+	b.ContainerID = newContainer.ID
+	b.IntermediateImages = append(append([]string{}, b.IntermediateImages...), img.ID)
+	pending, credited := layerHistory(b.History[b.CommittedHistory:])
+	b.History = append(b.History[:b.CommittedHistory], pending...)
+	if !credited {
+		b.AddHistory(b.CreatedBy, false)
+	}
+	b.CommittedHistory = len(b.History)
+	b.Mounts = []string{}
+	b.MountPoint = ""
+	if err = b.Save(); err == nil {
+		err = b.store.SetNames(newContainer.ID, container.Names)
+	}
+	if err != nil {
+		*b = oldState
+		if err2 := b.store.DeleteContainer(newContainer.ID); err2 != nil {
+			logrus.Errorf("error deleting container %q: %v", newContainer.ID, err2)
+		}
+		if _, err2 := b.store.DeleteImage(img.ID, true); err2 != nil {
+			logrus.Errorf("error deleting image %q: %v", img.ID, err2)
+		}
+		return fmt.Errorf("error replacing build container %q: %v", container.ID, err)
+	}
+	// Bring along the blobs that we generated for the layers which we
+	// stored earlier.
+	if err = moveLayerBlobCache(b.store, container.ID, newContainer.ID); err != nil {
+		// We'll just have to generate them again.
+		logrus.Warnf("error moving cached layer blobs: %v", err)
+	}
+	if err = b.store.DeleteContainer(container.ID); err != nil {
+		// The new container has taken over, so this only leaves
+		// some clutter behind.
+		logrus.Errorf("error deleting old build container %q: %v", container.ID, err)
+	}
+
+	if mounted {
+		// Point any links that we made to the old container's root
+		// filesystem at the new one's.
+		mountPoint, err := b.Mount("")
+		if err != nil {
+			return fmt.Errorf("error mounting build container: %v", err)
+		}
+		for _, link := range b.Links {
+			if err = os.Remove(link); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing symlink %q: %v", link, err)
+			}
+			if err = os.Symlink(mountPoint, link); err != nil {
+				return fmt.Errorf("error creating symlink %q: %v", link, err)
+			}
+		}
+	}
+	return b.Save()
+}
+
+// moveLayerBlobCache moves the directory where we keep the blobs that we've
+// generated for stored layers from one container's directory to another's.
+func moveLayerBlobCache(store storage.Store, from, to string) error {
+	fromDir, err := store.GetContainerDirectory(from)
+	if err != nil {
+		return err
+	}
+	toDir, err := store.GetContainerDirectory(to)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(fromDir, layerBlobCacheDir), filepath.Join(toDir, layerBlobCacheDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
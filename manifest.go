@@ -0,0 +1,627 @@
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/copy"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/manifest"
+	ociLayout "github.com/containers/image/oci/layout"
+	"github.com/containers/image/signature"
+	is "github.com/containers/image/storage"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/containers/storage/storage"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// manifestListDir is the directory under the Store's graph root where
+	// we keep the state of manifest lists.
+	manifestListDir = Package + "-manifests"
+	// ManifestListFormatOCI is the format name for OCI image indexes.
+	ManifestListFormatOCI = "oci"
+	// ManifestListFormatDocker is the format name for Docker manifest
+	// lists.
+	ManifestListFormatDocker = "docker"
+)
+
+// ManifestList is a list of images, each built for a different platform,
+// which is assembled in local storage and then written out as either an OCI
+// image index or a Docker manifest list.
+type ManifestList struct {
+	store storage.Store
+
+	// Name is the name which the list was created with.
+	Name string `json:"name"`
+	// Format is either ManifestListFormatOCI or ManifestListFormatDocker,
+	// and controls which type of list Manifest() produces by default.
+	Format string `json:"format"`
+	// Annotations are added to the list, if its format supports them.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Instances are the images in the list.
+	Instances []ManifestInstance `json:"instances"`
+}
+
+// ManifestInstance describes an image in a ManifestList.
+type ManifestInstance struct {
+	// ImageID is the ID of the image in the Store.
+	ImageID string `json:"image-id"`
+	// ImageName is the name that the image was added using.
+	ImageName string `json:"image-name,omitempty"`
+	// Digest, MediaType and Size describe the image's manifest.
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"media-type"`
+	Size      int64         `json:"size"`
+	// Platform is initialized from the image's configuration when the
+	// image is added, and can be changed using Annotate().
+	Platform v1.Platform `json:"platform"`
+	// Annotations are added to the image's entry in the list, if the
+	// list's format supports them.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ManifestAnnotateOptions are used to change the information about an image
+// in a ManifestList.  Fields which are not set are left unchanged.
+type ManifestAnnotateOptions struct {
+	OS           string
+	Architecture string
+	Variant      string
+	OSVersion    string
+	OSFeatures   []string
+	Features     []string
+	Annotations  map[string]string
+}
+
+// ManifestPushOptions control how a ManifestList is written to a location.
+type ManifestPushOptions struct {
+	// Format overrides the Format of the ManifestList, if it is set.
+	Format string
+	// SignaturePolicyPath specifies an override location for the signature
+	// policy which should be used for verifying the images as they are
+	// written.
+	SignaturePolicyPath string
+	// SignBy is the fingerprint of a GPG key to use for signing the list
+	// and the images in it.
+	SignBy string
+	// ReportWriter, if set, receives progress messages.
+	ReportWriter io.Writer
+}
+
+// manifestListDescriptor is an entry in an OCI image index or a Docker
+// manifest list.  We don't use the image-spec's types for these because
+// they don't include the mediaType field that the list's readers use to
+// tell what kind of list they're looking at.
+type manifestListDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      digest.Digest     `json:"digest"`
+	Platform    v1.Platform       `json:"platform"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifestListDocument is an OCI image index or a Docker manifest list.
+type manifestListDocument struct {
+	SchemaVersion int                      `json:"schemaVersion"`
+	MediaType     string                   `json:"mediaType"`
+	Manifests     []manifestListDescriptor `json:"manifests"`
+	Annotations   map[string]string        `json:"annotations,omitempty"`
+}
+
+// manifestListMediaType returns the media type of a list in the named format.
+func manifestListMediaType(format string) (string, error) {
+	switch format {
+	case ManifestListFormatOCI:
+		return v1.MediaTypeImageManifestList, nil
+	case ManifestListFormatDocker:
+		return manifest.DockerV2ListMediaType, nil
+	}
+	return "", fmt.Errorf("unrecognized manifest list format %q", format)
+}
+
+// manifestListPath returns the location of the file which holds the state of
+// the named list.  Names can contain characters which aren't allowed in file
+// names, so the file is named using a digest of the list's name.
+func manifestListPath(store storage.Store, name string) string {
+	return filepath.Join(store.GetGraphRoot(), manifestListDir, digest.FromString(name).Hex()+".json")
+}
+
+// CreateManifestList creates a new, empty, ManifestList with the specified
+// name, which will produce a list in the specified format.
+func CreateManifestList(store storage.Store, name, format string) (*ManifestList, error) {
+	if name == "" {
+		return nil, fmt.Errorf("manifest list name must be specified")
+	}
+	if format == "" {
+		format = ManifestListFormatOCI
+	}
+	if _, err := manifestListMediaType(format); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(manifestListPath(store, name)); err == nil {
+		return nil, fmt.Errorf("manifest list %q already exists", name)
+	}
+	list := &ManifestList{
+		store:     store,
+		Name:      name,
+		Format:    format,
+		Instances: []ManifestInstance{},
+	}
+	return list, list.Save()
+}
+
+// OpenManifestList reads the state of the named ManifestList.
+func OpenManifestList(store storage.Store, name string) (*ManifestList, error) {
+	state, err := ioutil.ReadFile(manifestListPath(store, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no manifest list named %q", name)
+		}
+		return nil, err
+	}
+	list := &ManifestList{}
+	if err = json.Unmarshal(state, list); err != nil {
+		return nil, fmt.Errorf("error parsing manifest list %q: %v", name, err)
+	}
+	if list.Name != name {
+		return nil, fmt.Errorf("manifest list %q is actually named %q", name, list.Name)
+	}
+	list.store = store
+	return list, nil
+}
+
+// Save saves the ManifestList's current state.
+func (m *ManifestList) Save() error {
+	state, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := manifestListPath(m.store, m.Name)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(path, state, 0600)
+}
+
+// Delete removes the ManifestList's state.  The images in it are not
+// affected.
+func (m *ManifestList) Delete() error {
+	return os.Remove(manifestListPath(m.store, m.Name))
+}
+
+// findImage locates an image in the Store using its ID or one of its names.
+func findImage(store storage.Store, image string) (*storage.Image, error) {
+	if img, err := store.GetImage(image); err == nil {
+		return img, nil
+	}
+	ref, err := is.Transport.ParseStoreReference(store, image)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
+	}
+	img, err := is.Transport.GetStoreImage(store, ref)
+	if err != nil {
+		return nil, fmt.Errorf("no such image %q: %v", image, err)
+	}
+	return img, nil
+}
+
+// AddImage adds an image from the Store to the ManifestList, taking its
+// platform from the OS and architecture in its configuration, and returns the
+// digest of its manifest.  If the image is already in the list, its entry is
+// replaced.
+func (m *ManifestList) AddImage(image string) (digest.Digest, error) {
+	img, err := findImage(m.store, image)
+	if err != nil {
+		return "", err
+	}
+	ref, err := is.Transport.ParseStoreReference(m.store, "@"+img.ID)
+	if err != nil {
+		return "", fmt.Errorf("no such image %q: %v", "@"+img.ID, err)
+	}
+	src, err := ref.NewImage(getSystemContext(""))
+	if err != nil {
+		return "", fmt.Errorf("error instantiating image %q: %v", image, err)
+	}
+	defer src.Close()
+	manifestBytes, manifestType, err := src.Manifest()
+	if err != nil {
+		return "", fmt.Errorf("error reading manifest of image %q: %v", image, err)
+	}
+	info, err := src.Inspect()
+	if err != nil {
+		return "", fmt.Errorf("error reading configuration of image %q: %v", image, err)
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", fmt.Errorf("error computing digest of manifest of image %q: %v", image, err)
+	}
+	instance := ManifestInstance{
+		ImageID:   img.ID,
+		ImageName: image,
+		Digest:    manifestDigest,
+		MediaType: manifestType,
+		Size:      int64(len(manifestBytes)),
+		Platform: v1.Platform{
+			OS:           info.Os,
+			Architecture: info.Architecture,
+		},
+	}
+	for i := range m.Instances {
+		if m.Instances[i].ImageID == img.ID || m.Instances[i].Digest == manifestDigest {
+			m.Instances[i] = instance
+			return manifestDigest, nil
+		}
+	}
+	m.Instances = append(m.Instances, instance)
+	return manifestDigest, nil
+}
+
+// findInstance locates an image in the ManifestList using either the digest
+// of its manifest, or the ID or name of the image in the Store.
+func (m *ManifestList) findInstance(instance string) (*ManifestInstance, error) {
+	for i := range m.Instances {
+		if string(m.Instances[i].Digest) == instance || m.Instances[i].ImageID == instance || m.Instances[i].ImageName == instance {
+			return &m.Instances[i], nil
+		}
+	}
+	if img, err := findImage(m.store, instance); err == nil {
+		for i := range m.Instances {
+			if m.Instances[i].ImageID == img.ID {
+				return &m.Instances[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no image matching %q in manifest list %q", instance, m.Name)
+}
+
+// Annotate changes the information recorded about an image in the
+// ManifestList.  The image is identified using the digest of its manifest, or
+// the ID or name of the image in the Store.
+func (m *ManifestList) Annotate(instance string, options ManifestAnnotateOptions) error {
+	entry, err := m.findInstance(instance)
+	if err != nil {
+		return err
+	}
+	if options.OS != "" {
+		entry.Platform.OS = options.OS
+	}
+	if options.Architecture != "" {
+		entry.Platform.Architecture = options.Architecture
+	}
+	if options.Variant != "" {
+		entry.Platform.Variant = options.Variant
+	}
+	if options.OSVersion != "" {
+		entry.Platform.OSVersion = options.OSVersion
+	}
+	if len(options.OSFeatures) > 0 {
+		entry.Platform.OSFeatures = append([]string{}, options.OSFeatures...)
+	}
+	if len(options.Features) > 0 {
+		entry.Platform.Features = append([]string{}, options.Features...)
+	}
+	for k, v := range options.Annotations {
+		if entry.Annotations == nil {
+			entry.Annotations = make(map[string]string)
+		}
+		entry.Annotations[k] = v
+	}
+	return nil
+}
+
+// buildList generates a list in the specified format which describes the
+// instances.  Docker manifest lists don't include annotations, so they are
+// dropped when generating one.
+func (m *ManifestList) buildList(format string, instances []ManifestInstance) ([]byte, error) {
+	mediaType, err := manifestListMediaType(format)
+	if err != nil {
+		return nil, err
+	}
+	list := manifestListDocument{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Manifests:     []manifestListDescriptor{},
+	}
+	if format == ManifestListFormatOCI {
+		list.Annotations = m.Annotations
+	}
+	for _, instance := range instances {
+		descriptor := manifestListDescriptor{
+			MediaType: instance.MediaType,
+			Size:      instance.Size,
+			Digest:    instance.Digest,
+			Platform:  instance.Platform,
+		}
+		if format == ManifestListFormatOCI {
+			descriptor.Annotations = instance.Annotations
+		}
+		list.Manifests = append(list.Manifests, descriptor)
+	}
+	return json.Marshal(&list)
+}
+
+// Manifest generates the list in its Format, describing the images' manifests
+// as they are in local storage.
+func (m *ManifestList) Manifest() ([]byte, error) {
+	return m.buildList(m.Format, m.Instances)
+}
+
+// instanceReference wraps the location that a ManifestList is being pushed
+// to, so that an image in the list can be written there without replacing
+// whatever the location's name or tag refers to: the image's manifest is only
+// stored under its digest, and is recorded, along with its type, so that the
+// list can describe it.  Only registries and OCI layouts can hold manifests
+// that way.  If requiredType is set, the image is converted to use that type
+// of manifest.
+type instanceReference struct {
+	types.ImageReference
+	requiredType string
+	manifest     []byte
+	manifestType string
+}
+
+// instanceDestination is the ImageDestination that instanceReference opens.
+// If the manifest is written to a registry, digested is the destination
+// which it was written to.
+type instanceDestination struct {
+	types.ImageDestination
+	ref      *instanceReference
+	sc       *types.SystemContext
+	digested types.ImageDestination
+}
+
+// newInstanceReference returns an instanceReference for writing images to
+// the destination, using manifests of the required type if one is specified,
+// or an error if the destination can only hold one manifest.
+func newInstanceReference(dest types.ImageReference, requiredType string) (*instanceReference, error) {
+	switch dest.Transport().Name() {
+	case docker.Transport.Name():
+		if dest.DockerReference() == nil {
+			return nil, fmt.Errorf("can't write images to %q: no name to write them under", transports.ImageName(dest))
+		}
+	case ociLayout.Transport.Name():
+	default:
+		return nil, fmt.Errorf("can't write manifest list to %q: only registries and OCI layouts can hold the images in it", transports.ImageName(dest))
+	}
+	return &instanceReference{ImageReference: dest, requiredType: requiredType}, nil
+}
+
+func (r *instanceReference) NewImageDestination(sc *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := r.ImageReference.NewImageDestination(sc)
+	if err != nil {
+		return nil, err
+	}
+	return &instanceDestination{ImageDestination: dest, ref: r, sc: sc}, nil
+}
+
+func (d *instanceDestination) SupportedManifestMIMETypes() []string {
+	if d.ref.requiredType != "" {
+		return []string{d.ref.requiredType}
+	}
+	return d.ImageDestination.SupportedManifestMIMETypes()
+}
+
+func (d *instanceDestination) PutManifest(m []byte) error {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return err
+	}
+	switch d.ref.Transport().Name() {
+	case docker.Transport.Name():
+		named, err := reference.WithDigest(reference.TrimNamed(d.ref.DockerReference()), manifestDigest)
+		if err != nil {
+			return err
+		}
+		ref, err := docker.NewReference(named)
+		if err != nil {
+			return err
+		}
+		if d.digested, err = ref.NewImageDestination(d.sc); err != nil {
+			return err
+		}
+		if err = d.digested.PutManifest(m); err != nil {
+			return err
+		}
+	case ociLayout.Transport.Name():
+		// Manifests are blobs in OCI layouts, and we leave the tag
+		// alone.
+		dir, _, err := splitOCILayoutReference(d.ref)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "blobs", manifestDigest.Algorithm().String(), manifestDigest.Hex())
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(path, m, 0644); err != nil {
+			return err
+		}
+	}
+	d.ref.manifest = append([]byte{}, m...)
+	// OCI manifests don't always say what they are, so if the
+	// destination only accepts one type, that's what we just wrote.
+	d.ref.manifestType = manifest.GuessMIMEType(m)
+	if supported := d.SupportedManifestMIMETypes(); len(supported) == 1 {
+		d.ref.manifestType = supported[0]
+	}
+	return nil
+}
+
+func (d *instanceDestination) PutSignatures(signatures [][]byte) error {
+	if d.digested != nil {
+		return d.digested.PutSignatures(signatures)
+	}
+	return d.ImageDestination.PutSignatures(signatures)
+}
+
+func (d *instanceDestination) Commit() error {
+	if d.digested != nil {
+		if err := d.digested.Commit(); err != nil {
+			return err
+		}
+	}
+	return d.ImageDestination.Commit()
+}
+
+func (d *instanceDestination) Close() {
+	if d.digested != nil {
+		d.digested.Close()
+	}
+	d.ImageDestination.Close()
+}
+
+// splitOCILayoutReference returns the directory and tag which a reference to
+// an image in an OCI layout points to.
+func splitOCILayoutReference(ref types.ImageReference) (string, string, error) {
+	spec := ref.StringWithinTransport()
+	sep := strings.LastIndex(spec, ":")
+	if sep == -1 {
+		return "", "", fmt.Errorf("error parsing OCI layout reference %q", spec)
+	}
+	return spec[:sep], spec[sep+1:], nil
+}
+
+// ociListDestination writes a manifest list to an OCI layout.  The layout's
+// ImageDestination always describes what it's given as an image manifest in
+// the descriptor that it writes for the tag, so we replace that descriptor
+// with one which uses the list's media type.
+type ociListDestination struct {
+	types.ImageDestination
+	dir, tag  string
+	mediaType string
+}
+
+func (d *ociListDestination) PutManifest(m []byte) error {
+	if err := d.ImageDestination.PutManifest(m); err != nil {
+		return err
+	}
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return err
+	}
+	descriptor, err := json.Marshal(&v1.Descriptor{
+		MediaType: d.mediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(m)),
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(d.dir, "refs", d.tag), descriptor, 0644)
+}
+
+// Push writes each of the images in the ManifestList to the destination,
+// which must be a registry or an OCI layout, and then writes the list itself
+// there.  The images are only stored under the digests of their manifests,
+// so that none of them ever takes the place of whatever the destination
+// referred to before the list does.  Writing an image can change its
+// manifest, for example if the destination doesn't accept OCI manifests, or
+// if a Docker manifest list is being written, in which case the images are
+// converted to use Docker v2 schema 2 manifests, so the list describes the
+// manifests which were written.
+func (m *ManifestList) Push(dest types.ImageReference, options ManifestPushOptions) error {
+	if len(m.Instances) == 0 {
+		return fmt.Errorf("manifest list %q is empty", m.Name)
+	}
+	format := options.Format
+	if format == "" {
+		format = m.Format
+	}
+	listType, err := manifestListMediaType(format)
+	if err != nil {
+		return err
+	}
+	requiredType := ""
+	if format == ManifestListFormatDocker {
+		requiredType = manifest.DockerV2Schema2MediaType
+	}
+	if options.SignBy != "" && dest.DockerReference() == nil {
+		return fmt.Errorf("can't sign manifest list written to %q: no name to sign it for", transports.ImageName(dest))
+	}
+	if _, err = newInstanceReference(dest, requiredType); err != nil {
+		return err
+	}
+	systemContext := getSystemContext(options.SignaturePolicyPath)
+	policy, err := signature.DefaultPolicy(systemContext)
+	if err != nil {
+		return err
+	}
+	policyContext, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return err
+	}
+	defer policyContext.Destroy()
+
+	pushed := []ManifestInstance{}
+	for _, instance := range m.Instances {
+		src, err := is.Transport.ParseStoreReference(m.store, "@"+instance.ImageID)
+		if err != nil {
+			return fmt.Errorf("no such image %q: %v", "@"+instance.ImageID, err)
+		}
+		instanceDest, err := newInstanceReference(dest, requiredType)
+		if err != nil {
+			return err
+		}
+		copyOptions := getCopyOptions()
+		copyOptions.SignBy = options.SignBy
+		copyOptions.ReportWriter = options.ReportWriter
+		if err = copy.Image(policyContext, instanceDest, src, copyOptions); err != nil {
+			return fmt.Errorf("error writing image %q to %q: %v", instance.ImageName, transports.ImageName(dest), err)
+		}
+		manifestBytes := instanceDest.manifest
+		manifestDigest, err := manifest.Digest(manifestBytes)
+		if err != nil {
+			return fmt.Errorf("error computing digest of manifest of image %q: %v", instance.ImageName, err)
+		}
+		instance.Digest = manifestDigest
+		instance.MediaType = instanceDest.manifestType
+		instance.Size = int64(len(manifestBytes))
+		pushed = append(pushed, instance)
+	}
+
+	list, err := m.buildList(format, pushed)
+	if err != nil {
+		return err
+	}
+	destination, err := dest.NewImageDestination(systemContext)
+	if err != nil {
+		return fmt.Errorf("error opening %q for writing: %v", transports.ImageName(dest), err)
+	}
+	if dest.Transport().Name() == ociLayout.Transport.Name() {
+		dir, tag, err := splitOCILayoutReference(dest)
+		if err != nil {
+			destination.Close()
+			return err
+		}
+		destination = &ociListDestination{ImageDestination: destination, dir: dir, tag: tag, mediaType: listType}
+	}
+	defer destination.Close()
+	sigs := [][]byte{}
+	if options.SignBy != "" {
+		mech, err := signature.NewGPGSigningMechanism()
+		if err != nil {
+			return fmt.Errorf("error initializing GPG: %v", err)
+		}
+		sig, err := signature.SignDockerManifest(list, dest.DockerReference().String(), mech, options.SignBy)
+		if err != nil {
+			return fmt.Errorf("error signing manifest list: %v", err)
+		}
+		sigs = append(sigs, sig)
+	}
+	if err = destination.PutManifest(list); err != nil {
+		return fmt.Errorf("error writing manifest list to %q: %v", transports.ImageName(dest), err)
+	}
+	if err = destination.PutSignatures(sigs); err != nil {
+		return fmt.Errorf("error writing manifest list signatures to %q: %v", transports.ImageName(dest), err)
+	}
+	return destination.Commit()
+}
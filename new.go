@@ -4,7 +4,10 @@ import (
 	"fmt"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
 	is "github.com/containers/image/storage"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
 	"github.com/containers/storage/storage"
 )
 
@@ -24,10 +27,26 @@ func newBuilder(store storage.Store, options BuilderOptions) (*Builder, error) {
 		options.FromImage = ""
 	}
 	image := options.FromImage
+	if options.RootFS != "" || options.Tarball != "" {
+		if image != "" {
+			return nil, fmt.Errorf("a root filesystem can only be used to populate a container which is not based on an image")
+		}
+		if options.RootFS != "" && options.Tarball != "" {
+			return nil, fmt.Errorf("only one of a directory or a tarball can be used to populate a container")
+		}
+	}
 	if options.Container != "" {
 		name = options.Container
 	} else {
-		if image != "" {
+		if srcRef, err := transports.ParseImageName(image); err == nil {
+			// Base the name on the image's name, if it has one,
+			// instead of on where it's being read from.
+			if named := srcRef.DockerReference(); named != nil {
+				name = reference.FamiliarName(named) + "-" + name
+			} else {
+				name = srcRef.Transport().Name() + "-" + name
+			}
+		} else if image != "" {
 			name = image + "-" + name
 		}
 	}
@@ -49,33 +68,66 @@ func newBuilder(store storage.Store, options BuilderOptions) (*Builder, error) {
 
 	imageID := ""
 	if image != "" {
-		if options.PullAlways {
-			err := pullImage(store, options, systemContext)
+		var ref types.ImageReference
+		if srcRef, err := transports.ParseImageName(image); err == nil && srcRef.Transport().Name() != is.Transport.Name() {
+			// The image's location was spelled out, so copy it from
+			// there, if we don't already have a copy of it.
+			img, err = importImage(store, srcRef, options, systemContext)
 			if err != nil {
-				return nil, fmt.Errorf("error pulling image %q: %v", image, err)
+				return nil, fmt.Errorf("error importing image %q: %v", image, err)
 			}
-		}
-		ref, err := is.Transport.ParseStoreReference(store, image)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
-		}
-		img, err = is.Transport.GetStoreImage(store, ref)
-		if err != nil {
-			if err == storage.ErrImageUnknown && !options.PullIfMissing {
-				return nil, fmt.Errorf("no such image %q: %v", image, err)
+			if ref, err = is.Transport.ParseStoreReference(store, "@"+img.ID); err != nil {
+				return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
 			}
-			err = pullImage(store, options, systemContext)
-			if err != nil {
-				return nil, fmt.Errorf("error pulling image %q: %v", image, err)
+		} else if options.OS != "" || options.Architecture != "" || options.Variant != "" {
+			// Images for specific platforms are kept under
+			// platform-qualified names.
+			var err error
+			name := platformImageName(image, options.OS, options.Architecture, options.Variant)
+			if !options.PullAlways {
+				img, err = store.GetImage(name)
+				if err != nil && !options.PullIfMissing {
+					return nil, fmt.Errorf("no such image %q: %v", name, err)
+				}
+			}
+			if options.PullAlways || img == nil {
+				if img, err = pullImage(store, options, systemContext); err != nil {
+					return nil, fmt.Errorf("error pulling image %q: %v", image, err)
+				}
+			}
+			if ref, err = is.Transport.ParseStoreReference(store, "@"+img.ID); err != nil {
+				return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
+			}
+		} else {
+			if options.PullAlways {
+				_, err := pullImage(store, options, systemContext)
+				if err != nil {
+					return nil, fmt.Errorf("error pulling image %q: %v", image, err)
+				}
 			}
-			ref, err = is.Transport.ParseStoreReference(store, image)
+			storeRef, err := is.Transport.ParseStoreReference(store, image)
 			if err != nil {
 				return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
 			}
-			img, err = is.Transport.GetStoreImage(store, ref)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("no such image %q: %v", image, err)
+			img, err = is.Transport.GetStoreImage(store, storeRef)
+			if err != nil {
+				if err == storage.ErrImageUnknown && !options.PullIfMissing {
+					return nil, fmt.Errorf("no such image %q: %v", image, err)
+				}
+				_, err = pullImage(store, options, systemContext)
+				if err != nil {
+					return nil, fmt.Errorf("error pulling image %q: %v", image, err)
+				}
+				storeRef, err = is.Transport.ParseStoreReference(store, image)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing reference to image %q: %v", image, err)
+				}
+				img, err = is.Transport.GetStoreImage(store, storeRef)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("no such image %q: %v", image, err)
+			}
+			ref = storeRef
 		}
 		imageID = img.ID
 		src, err := ref.NewImage(systemContext)
@@ -91,6 +143,15 @@ func newBuilder(store storage.Store, options BuilderOptions) (*Builder, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error reading image manifest: %v", err)
 		}
+		if options.OS != "" || options.Architecture != "" {
+			info, err := src.Inspect()
+			if err != nil {
+				return nil, fmt.Errorf("error reading image configuration: %v", err)
+			}
+			if (options.OS != "" && info.Os != options.OS) || (options.Architecture != "" && info.Architecture != options.Architecture) {
+				return nil, fmt.Errorf("image %q is for %s/%s, not the requested platform", image, info.Os, info.Architecture)
+			}
+		}
 	}
 
 	coptions := storage.ContainerOptions{}
@@ -126,6 +187,17 @@ func newBuilder(store storage.Store, options BuilderOptions) (*Builder, error) {
 		Labels:      map[string]string{},
 		Volumes:     []string{},
 		Arg:         map[string]string{},
+
+		OS:           options.OS,
+		Architecture: options.Architecture,
+
+		OnBuildTriggers: readDockerConfigExtensions(config).OnBuild,
+	}
+
+	if options.RootFS != "" || options.Tarball != "" {
+		if err = builder.populate(options); err != nil {
+			return nil, err
+		}
 	}
 
 	if options.Mount {
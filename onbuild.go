@@ -0,0 +1,328 @@
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mattn/go-shellwords"
+)
+
+// OnBuildOptions control how the ONBUILD triggers which were inherited from
+// a source image are run.
+type OnBuildOptions struct {
+	// ContextDir is the directory which relative sources for COPY and ADD
+	// triggers are read from.  If not set, the current directory is used.
+	ContextDir string
+	// Run is passed to Run() for RUN triggers.
+	Run RunOptions
+	// AddAndCopy is passed to Add() for COPY and ADD triggers.
+	AddAndCopy AddAndCopyOptions
+}
+
+// splitInstruction separates a Dockerfile-style instruction into its keyword,
+// which is returned in upper case, and its arguments.
+func splitInstruction(instruction string) (string, string) {
+	fields := strings.SplitN(strings.TrimSpace(instruction), " ", 2)
+	keyword := strings.ToUpper(strings.TrimSpace(fields[0]))
+	if len(fields) < 2 {
+		return keyword, ""
+	}
+	return keyword, strings.TrimSpace(fields[1])
+}
+
+// parseJSONOrWords parses the arguments of an instruction, which may be in
+// either JSON array form or a list of words.
+func parseJSONOrWords(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		list := []string{}
+		if err := json.Unmarshal([]byte(args), &list); err == nil {
+			return list, nil
+		}
+	}
+	return shellwords.Parse(args)
+}
+
+// commandForm parses the arguments of a RUN, CMD, or ENTRYPOINT instruction,
+// which are either a JSON array or a command which is run using the shell.
+func (b *Builder) commandForm(args string) []string {
+	if strings.HasPrefix(args, "[") {
+		list := []string{}
+		if err := json.Unmarshal([]byte(args), &list); err == nil {
+			return list
+		}
+	}
+	shell := b.Shell
+	if len(shell) == 0 {
+		shell = readDockerConfigExtensions(b.Config).Shell
+	}
+	if len(shell) == 0 {
+		shell = []string{"/bin/sh", "-c"}
+	}
+	return append(append([]string{}, shell...), args)
+}
+
+// parseKeyValues parses the arguments of an ENV or LABEL instruction, which
+// are either a single key followed by a value, or a list of key=value pairs.
+func parseKeyValues(args string) ([][2]string, error) {
+	words, err := shellwords.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no values specified")
+	}
+	if !strings.Contains(words[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("no value specified for %q", words[0])
+		}
+		return [][2]string{{fields[0], strings.TrimSpace(fields[1])}}, nil
+	}
+	pairs := [][2]string{}
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) < 2 {
+			return nil, fmt.Errorf("expected %q to be in the form key=value", word)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// parseHealthcheck parses the arguments of a HEALTHCHECK instruction, which
+// are either NONE, or options followed by CMD and a command which is either a
+// JSON array or a command which is run using the shell.
+func parseHealthcheck(args string) (*container.HealthConfig, error) {
+	if strings.ToUpper(args) == "NONE" {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	healthcheck := &container.HealthConfig{}
+	for strings.HasPrefix(args, "--") {
+		fields := strings.SplitN(args, " ", 2)
+		option := strings.SplitN(strings.TrimPrefix(fields[0], "--"), "=", 2)
+		if len(option) < 2 {
+			return nil, fmt.Errorf("no value specified for option %q", fields[0])
+		}
+		var err error
+		switch option[0] {
+		case "interval":
+			healthcheck.Interval, err = time.ParseDuration(option[1])
+		case "timeout":
+			healthcheck.Timeout, err = time.ParseDuration(option[1])
+		case "retries":
+			healthcheck.Retries, err = strconv.Atoi(option[1])
+		default:
+			return nil, fmt.Errorf("unsupported option %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing option %q: %v", fields[0], err)
+		}
+		args = ""
+		if len(fields) > 1 {
+			args = strings.TrimSpace(fields[1])
+		}
+	}
+	keyword, command := splitInstruction(args)
+	if keyword != "CMD" || command == "" {
+		return nil, fmt.Errorf("expected CMD followed by a command")
+	}
+	if strings.HasPrefix(command, "[") {
+		list := []string{}
+		if err := json.Unmarshal([]byte(command), &list); err == nil {
+			healthcheck.Test = append([]string{"CMD"}, list...)
+			return healthcheck, nil
+		}
+	}
+	healthcheck.Test = []string{"CMD-SHELL", command}
+	return healthcheck, nil
+}
+
+// RunOnBuild runs the ONBUILD triggers which were inherited from the source
+// image, in order, applying them to the working container using the
+// Builder's other methods.  Triggers which can not be applied to a working
+// container, such as FROM or another ONBUILD, are treated as errors.  Once
+// they have all been applied, the triggers are discarded, so that they aren't
+// run again.
+func (b *Builder) RunOnBuild(options OnBuildOptions) error {
+	if len(b.OnBuildTriggers) == 0 {
+		return nil
+	}
+	if err := b.runOnBuildTriggers(options); err != nil {
+		return err
+	}
+	b.OnBuildTriggers = nil
+	return b.Save()
+}
+
+func (b *Builder) runOnBuildTriggers(options OnBuildOptions) error {
+	// Mount the container if it isn't already mounted, and only for as
+	// long as we need it, without disturbing any links to its root.
+	container, err := b.store.GetContainer(b.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error reading build container %q: %v", b.ContainerID, err)
+	}
+	layer, err := b.store.GetLayer(container.LayerID)
+	if err != nil {
+		return fmt.Errorf("unable to read layer %q: %v", container.LayerID, err)
+	}
+	if layer.MountCount == 0 || b.MountPoint == "" {
+		mountPoint, err := b.store.Mount(b.ContainerID, "")
+		if err != nil {
+			return fmt.Errorf("error mounting build container: %v", err)
+		}
+		previous := b.MountPoint
+		b.MountPoint = mountPoint
+		defer func() {
+			b.MountPoint = previous
+			if err2 := b.store.Unmount(b.ContainerID); err2 != nil {
+				logrus.Errorf("error unmounting build container: %v", err2)
+			}
+		}()
+	}
+	for _, trigger := range b.OnBuildTriggers {
+		logrus.Debugf("running ONBUILD trigger %q", trigger)
+		if err := b.runOnBuildTrigger(trigger, options); err != nil {
+			return fmt.Errorf("error running ONBUILD trigger %q: %v", trigger, err)
+		}
+	}
+	return nil
+}
+
+func (b *Builder) runOnBuildTrigger(trigger string, options OnBuildOptions) error {
+	keyword, args := splitInstruction(trigger)
+	switch keyword {
+	case "RUN":
+		return b.Run(b.commandForm(args), options.Run)
+	case "CMD":
+		b.SetCmd(b.commandForm(args))
+	case "ENTRYPOINT":
+		b.SetEntrypoint(b.commandForm(args))
+	case "ENV":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			b.SetEnv(pair[0] + "=" + b.Expand(pair[1]))
+		}
+	case "LABEL":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			b.SetLabel(b.Expand(pair[0]), b.Expand(pair[1]))
+		}
+	case "WORKDIR":
+		args = b.Expand(args)
+		if filepath.IsAbs(args) {
+			b.SetWorkDir(filepath.Clean(args))
+		} else {
+			b.SetWorkDir(filepath.Join(string(os.PathSeparator), b.Workdir, args))
+		}
+	case "USER":
+		b.SetUser(args)
+	case "MAINTAINER":
+		b.SetMaintainer(args)
+	case "STOPSIGNAL":
+		b.SetStopSignal(args)
+	case "HEALTHCHECK":
+		healthcheck, err := parseHealthcheck(args)
+		if err != nil {
+			return err
+		}
+		b.SetHealthcheck(healthcheck)
+	case "ARG":
+		words, err := shellwords.Parse(args)
+		if err != nil {
+			return err
+		}
+		for _, word := range words {
+			// Values which were already set, for example on
+			// the command line, override the trigger's defaults.
+			kv := strings.SplitN(word, "=", 2)
+			if _, ok := b.Arg[kv[0]]; ok {
+				continue
+			}
+			value := ""
+			if len(kv) > 1 {
+				value = b.Expand(kv[1])
+			}
+			b.SetArg(kv[0], value)
+		}
+	case "SHELL":
+		shell, err := parseJSONOrWords(args)
+		if err != nil {
+			return err
+		}
+		b.SetShell(shell)
+	case "EXPOSE", "VOLUME":
+		values, err := parseJSONOrWords(args)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if keyword == "EXPOSE" {
+				b.ExposePort(value)
+			} else {
+				b.AddVolume(value)
+			}
+		}
+	case "COPY", "ADD":
+		return b.runOnBuildCopy(keyword == "ADD", args, options)
+	default:
+		return fmt.Errorf("unsupported instruction %q", keyword)
+	}
+	return nil
+}
+
+// runOnBuildCopy handles COPY and ADD triggers, reading relative sources from
+// the context directory, and sources named with --from from another
+// container or image.
+func (b *Builder) runOnBuildCopy(extract bool, args string, options OnBuildOptions) error {
+	words, err := parseJSONOrWords(args)
+	if err != nil {
+		return err
+	}
+	from := ""
+	for len(words) > 0 && strings.HasPrefix(words[0], "--") {
+		if strings.HasPrefix(words[0], "--from=") && !extract {
+			from = strings.TrimPrefix(words[0], "--from=")
+		} else {
+			return fmt.Errorf("unsupported option %q", words[0])
+		}
+		words = words[1:]
+	}
+	if len(words) < 2 {
+		return fmt.Errorf("expected at least one source and a destination")
+	}
+	dest := words[len(words)-1]
+	sources := words[:len(words)-1]
+	if from != "" {
+		return b.CopyFrom(from, dest, sources...)
+	}
+	contextDir := options.ContextDir
+	if contextDir == "" {
+		contextDir = "."
+	}
+	contextDir, err = filepath.Abs(contextDir)
+	if err != nil {
+		return err
+	}
+	for i, src := range sources {
+		if strings.Contains(src, "://") {
+			continue
+		}
+		if sources[i], err = resolveInRoot(contextDir, src); err != nil {
+			return err
+		}
+	}
+	return b.Add(dest, extract, options.AddAndCopy, sources...)
+}
@@ -0,0 +1,132 @@
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// platformImageReference wraps a reference to an image which might be a
+// manifest list, so that reading from it reads the image in the list which
+// was built for a particular platform, instead of the one which matches the
+// platform that we're running on.
+type platformImageReference struct {
+	types.ImageReference
+	os, arch, variant string
+}
+
+// platformImageSource is the ImageSource that platformImageReference opens.
+type platformImageSource struct {
+	types.ImageSource
+	ref *platformImageReference
+}
+
+// newPlatformImageReference returns a wrapped reference which will select the
+// image for the specified platform from a manifest list.  The OS and
+// architecture default to the ones we're running on.
+func newPlatformImageReference(ref types.ImageReference, os, arch, variant string) types.ImageReference {
+	if os == "" {
+		os = runtime.GOOS
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	return &platformImageReference{
+		ImageReference: ref,
+		os:             os,
+		arch:           arch,
+		variant:        variant,
+	}
+}
+
+// platformString formats a platform as OS/architecture[/variant], with the
+// OS and architecture defaulting to the ones we're running on.
+func platformString(os, arch, variant string) string {
+	if os == "" {
+		os = runtime.GOOS
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	platform := os + "/" + arch
+	if variant != "" {
+		platform += "/" + variant
+	}
+	return platform
+}
+
+// platformImageName returns the name that we give an image which was pulled
+// for a specific platform, in place of the name it was pulled using, so that
+// it doesn't take that name away from the image for the platform we're
+// running on.
+func platformImageName(name, os, arch, variant string) string {
+	return name + "@" + platformString(os, arch, variant)
+}
+
+func (r *platformImageReference) NewImageSource(sc *types.SystemContext, manifestTypes []string) (types.ImageSource, error) {
+	src, err := r.ImageReference.NewImageSource(sc, manifestTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &platformImageSource{ImageSource: src, ref: r}, nil
+}
+
+func (r *platformImageReference) NewImage(sc *types.SystemContext) (types.Image, error) {
+	src, err := r.NewImageSource(sc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return image.FromSource(src)
+}
+
+// matches checks if a platform is the one that we're looking for.  If no
+// variant was requested, any variant will do.
+func (r *platformImageReference) matches(platform v1.Platform) bool {
+	if platform.OS != r.os || platform.Architecture != r.arch {
+		return false
+	}
+	return r.variant == "" || platform.Variant == r.variant
+}
+
+// GetManifest returns the source's manifest, unless it's a manifest list, in
+// which case it returns the manifest of the image in the list which matches
+// the requested platform.
+func (s *platformImageSource) GetManifest() ([]byte, string, error) {
+	m, mt, err := s.ImageSource.GetManifest()
+	if err != nil {
+		return nil, "", err
+	}
+	if mt == "" {
+		mt = manifest.GuessMIMEType(m)
+	}
+	if mt != manifest.DockerV2ListMediaType && mt != v1.MediaTypeImageManifestList {
+		return m, mt, nil
+	}
+	list := manifestListDocument{}
+	if err = json.Unmarshal(m, &list); err != nil {
+		return nil, "", fmt.Errorf("error parsing manifest list: %v", err)
+	}
+	for _, instance := range list.Manifests {
+		if !s.ref.matches(instance.Platform) {
+			continue
+		}
+		m, mt, err := s.ImageSource.GetTargetManifest(instance.Digest)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading manifest %q from manifest list: %v", instance.Digest, err)
+		}
+		matches, err := manifest.MatchesDigest(m, instance.Digest)
+		if err != nil {
+			return nil, "", fmt.Errorf("error computing digest of manifest %q: %v", instance.Digest, err)
+		}
+		if !matches {
+			return nil, "", fmt.Errorf("manifest does not match digest %q in manifest list", instance.Digest)
+		}
+		return m, mt, nil
+	}
+	return nil, "", fmt.Errorf("no image for platform %s found in manifest list", platformString(s.ref.os, s.ref.arch, s.ref.variant))
+}
@@ -13,7 +13,52 @@ import (
 	"github.com/containers/storage/storage"
 )
 
-func pullImage(store storage.Store, options BuilderOptions, sc *types.SystemContext) error {
+// addImageNames adds names to an image in the Store, skipping any that it
+// already has, since SetNames() doesn't check.
+func addImageNames(store storage.Store, img *storage.Image, names ...string) error {
+	all := append([]string{}, img.Names...)
+	for _, name := range names {
+		present := false
+		for _, existing := range all {
+			if existing == name {
+				present = true
+				break
+			}
+		}
+		if !present {
+			all = append(all, name)
+		}
+	}
+	if len(all) == len(img.Names) {
+		return nil
+	}
+	return store.SetNames(img.ID, all)
+}
+
+// imageConfigID returns the digest of the configuration of the image that a
+// reference points to, in the form that we use as the ID of an image which we
+// copy into the Store from somewhere other than a registry, so that we can
+// tell if we've already copied it.
+func imageConfigID(srcRef types.ImageReference, sc *types.SystemContext) (string, error) {
+	src, err := srcRef.NewImage(sc)
+	if err != nil {
+		return "", fmt.Errorf("error reading image %q: %v", transports.ImageName(srcRef), err)
+	}
+	configDigest := src.ConfigInfo().Digest
+	src.Close()
+	if configDigest.Validate() != nil {
+		return "", fmt.Errorf("error reading configuration of image %q", transports.ImageName(srcRef))
+	}
+	return configDigest.Hex(), nil
+}
+
+// pullImage copies an image from a registry into the Store, and names it
+// using both the requested name and the name it was pulled using.  If a
+// specific platform was requested, the image is stored using the digest of
+// its configuration as its ID, and is named using platform-qualified names,
+// so that it doesn't take the names of the image for the platform that we're
+// running on.
+func pullImage(store storage.Store, options BuilderOptions, sc *types.SystemContext) (*storage.Image, error) {
 	name := options.FromImage
 
 	spec := name
@@ -25,7 +70,7 @@ func pullImage(store storage.Store, options BuilderOptions, sc *types.SystemCont
 	if err != nil {
 		srcRef2, err2 := transports.ParseImageName(spec)
 		if err2 != nil {
-			return fmt.Errorf("error parsing image name %q: %v", spec, err2)
+			return nil, fmt.Errorf("error parsing image name %q: %v", spec, err2)
 		}
 		srcRef = srcRef2
 	}
@@ -34,26 +79,51 @@ func pullImage(store storage.Store, options BuilderOptions, sc *types.SystemCont
 		name = reference.Domain(ref) + "/" + reference.Path(ref)
 	}
 
-	destRef, err := is.Transport.ParseStoreReference(store, name)
+	names := []string{options.FromImage, name}
+	dest := name
+	if options.OS != "" || options.Architecture != "" || options.Variant != "" {
+		srcRef = newPlatformImageReference(srcRef, options.OS, options.Architecture, options.Variant)
+		id, err := imageConfigID(srcRef, sc)
+		if err != nil {
+			return nil, err
+		}
+		dest = "@" + id
+		names = []string{
+			platformImageName(options.FromImage, options.OS, options.Architecture, options.Variant),
+			platformImageName(name, options.OS, options.Architecture, options.Variant),
+		}
+		// The image's ID is the digest of its configuration, so if
+		// we've pulled it before, we only need to give it the names.
+		if img, err := store.GetImage(id); err == nil {
+			logrus.Debugf("image %q is already present as %q", spec, id)
+			if err = addImageNames(store, img, names...); err != nil {
+				return nil, err
+			}
+			return img, nil
+		}
+	}
+
+	destRef, err := is.Transport.ParseStoreReference(store, dest)
 	if err != nil {
-		return fmt.Errorf("error parsing full image name %q: %v", name, err)
+		return nil, fmt.Errorf("error parsing full image name %q: %v", dest, err)
 	}
 
 	policy, err := signature.DefaultPolicy(sc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	policyContext, err := signature.NewPolicyContext(policy)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer policyContext.Destroy()
 
-	logrus.Debugf("copying %q to %q", spec, name)
+	logrus.Debugf("copying %q to %q", spec, dest)
 
 	err = copy.Image(policyContext, destRef, srcRef, getCopyOptions())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Go find the image, and attach the requested name to it, so that we
@@ -61,14 +131,67 @@ func pullImage(store storage.Store, options BuilderOptions, sc *types.SystemCont
 	// looks different.
 	destImage, err := is.Transport.GetStoreImage(store, destRef)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	names := append(destImage.Names, options.FromImage, name)
-	err = store.SetNames(destImage.ID, names)
+	err = addImageNames(store, destImage, names...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return destImage, nil
+}
+
+// importImage copies an image from a location which was given using a
+// transport-qualified name into the Store, unless an image with the same
+// configuration is already there.  If the location includes a name for the
+// image, the image is given that name.  The location itself is only recorded
+// in the Builder.
+func importImage(store storage.Store, srcRef types.ImageReference, options BuilderOptions, sc *types.SystemContext) (*storage.Image, error) {
+	if options.OS != "" || options.Architecture != "" || options.Variant != "" {
+		srcRef = newPlatformImageReference(srcRef, options.OS, options.Architecture, options.Variant)
+	}
+
+	// Use the digest of the image's configuration as its ID, so that we
+	// can tell if we've already imported it.
+	id, err := imageConfigID(srcRef, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := store.GetImage(id)
+	if err != nil {
+		destRef, err := is.Transport.ParseStoreReference(store, "@"+id)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing reference to image %q: %v", "@"+id, err)
+		}
+		policy, err := signature.DefaultPolicy(sc)
+		if err != nil {
+			return nil, err
+		}
+		policyContext, err := signature.NewPolicyContext(policy)
+		if err != nil {
+			return nil, err
+		}
+		defer policyContext.Destroy()
+		logrus.Debugf("copying %q to %q", transports.ImageName(srcRef), "@"+id)
+		if err = copy.Image(policyContext, destRef, srcRef, getCopyOptions()); err != nil {
+			return nil, err
+		}
+		if img, err = store.GetImage(id); err != nil {
+			return nil, err
+		}
+	} else {
+		logrus.Debugf("image %q is already present as %q", transports.ImageName(srcRef), id)
+	}
+
+	if named := srcRef.DockerReference(); named != nil {
+		named = reference.TagNameOnly(named)
+		if tagged, ok := named.(reference.NamedTagged); ok {
+			if err = addImageNames(store, img, tagged.Name()+":"+tagged.Tag()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return img, nil
 }
@@ -0,0 +1,66 @@
+package buildah
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/containers/image/copy"
+	"github.com/containers/image/signature"
+	is "github.com/containers/image/storage"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/containers/storage/storage"
+)
+
+// PushOptions can be used to alter how an image is copied somewhere.
+type PushOptions struct {
+	// SignaturePolicyPath specifies an override location for the signature
+	// policy which should be used for verifying the image as it is being
+	// written.  Except in specific circumstances, no value should be
+	// specified, indicating that the shared, system-wide default policy
+	// should be used.
+	SignaturePolicyPath string
+	// SignBy is the fingerprint of a GPG key to use for signing the
+	// image.  Signatures can only be written to destinations which have
+	// a Docker reference, i.e., a name, and which can store signatures.
+	SignBy string
+	// AdditionalTags are additional names to give the image in the
+	// destination, which must be an OCI layout or archive, a
+	// docker-archive tarball, or the local Store.
+	AdditionalTags []string
+	// ReportWriter, if set, receives progress messages.
+	ReportWriter io.Writer
+}
+
+// Push copies the contents of an image in the Store, which is identified by
+// its ID or one of its names, to a new location.
+func Push(store storage.Store, image string, dest types.ImageReference, options PushOptions) error {
+	if options.SignBy != "" && dest.DockerReference() == nil {
+		return fmt.Errorf("can't sign image written to %q: no name to sign it for", transports.ImageName(dest))
+	}
+	dest, err := withAdditionalTags(store, dest, options.AdditionalTags)
+	if err != nil {
+		return err
+	}
+	img, err := findImage(store, image)
+	if err != nil {
+		return err
+	}
+	src, err := is.Transport.ParseStoreReference(store, "@"+img.ID)
+	if err != nil {
+		return fmt.Errorf("no such image %q: %v", "@"+img.ID, err)
+	}
+	policy, err := signature.DefaultPolicy(getSystemContext(options.SignaturePolicyPath))
+	if err != nil {
+		return err
+	}
+	policyContext, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return err
+	}
+	defer policyContext.Destroy()
+	copyOptions := getCopyOptions()
+	copyOptions.SignBy = options.SignBy
+	copyOptions.ReportWriter = options.ReportWriter
+	return copy.Image(policyContext, dest, src, copyOptions)
+}
@@ -0,0 +1,122 @@
+package buildah
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spooledEntry is an entry from a layer diff whose contents have been saved
+// to a temporary file, at offset.
+type spooledEntry struct {
+	hdr    *tar.Header
+	offset int64
+}
+
+// entryKey returns the name that we sort layer entries by, which places
+// directories before their contents.
+func entryKey(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+}
+
+// clampLayer reads an uncompressed layer diff, and returns a copy of it in
+// which the entries are sorted by name, modification times which are later
+// than timestamp are set to timestamp, and access and change times are
+// dropped, so that identical contents produce identical diffs.  The diff's
+// contents are spooled in a temporary file in dir while this is done.
+func clampLayer(diff io.Reader, dir string, timestamp time.Time) (io.ReadCloser, error) {
+	spool, err := ioutil.TempFile(dir, "spool")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %v", err)
+	}
+	if err = os.Remove(spool.Name()); err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("error removing temporary file %q: %v", spool.Name(), err)
+	}
+	entries := []spooledEntry{}
+	offset := int64(0)
+	tr := tar.NewReader(diff)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			spool.Close()
+			return nil, fmt.Errorf("error reading layer: %v", err)
+		}
+		n, err := io.Copy(spool, tr)
+		if err != nil {
+			spool.Close()
+			return nil, fmt.Errorf("error spooling layer contents: %v", err)
+		}
+		entries = append(entries, spooledEntry{hdr: hdr, offset: offset})
+		offset += n
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entryKey(entries[i].hdr.Name) < entryKey(entries[j].hdr.Name)
+	})
+	reader, writer := io.Pipe()
+	go func() {
+		defer spool.Close()
+		writer.CloseWithError(writeClampedLayer(writer, spool, entries, timestamp))
+	}()
+	return reader, nil
+}
+
+// writeClampedLayer writes the sorted entries to w, taking their contents from
+// spool.  If sorting moved a hard link ahead of the file that it pointed to,
+// the link is written with the file's contents, and the file, along with any
+// other links to it, is written as a link to it instead.
+func writeClampedLayer(w io.Writer, spool *os.File, entries []spooledEntry, timestamp time.Time) error {
+	index := make(map[string]int)
+	for i, entry := range entries {
+		index[entryKey(entry.hdr.Name)] = i
+	}
+	written := make(map[string]bool)
+	renamed := make(map[string]string)
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		hdr := *entry.hdr
+		key := entryKey(hdr.Name)
+		content := entry
+		if target, ok := renamed[key]; ok {
+			// We already wrote this file's contents under the
+			// name of one of its links.
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = target
+			hdr.Size = 0
+		} else if hdr.Typeflag == tar.TypeLink {
+			linkKey := entryKey(hdr.Linkname)
+			if target, ok := renamed[linkKey]; ok {
+				hdr.Linkname = target
+			} else if i, ok := index[linkKey]; ok && !written[linkKey] {
+				content = entries[i]
+				linkname := hdr.Name
+				hdr = *content.hdr
+				hdr.Name = linkname
+				renamed[linkKey] = linkname
+			}
+		}
+		if hdr.ModTime.After(timestamp) {
+			hdr.ModTime = timestamp
+		}
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return err
+		}
+		if hdr.Size > 0 {
+			if _, err := io.Copy(tw, io.NewSectionReader(spool, content.offset, hdr.Size)); err != nil {
+				return err
+			}
+		}
+		written[key] = true
+	}
+	return tw.Close()
+}
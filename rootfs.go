@@ -0,0 +1,39 @@
+package buildah
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/storage/pkg/archive"
+)
+
+// populate fills a new container's root filesystem with the contents of the
+// directory tree or tarball which was specified in options, preserving
+// ownership, permissions, and extended attributes, and notes where they came
+// from in the Builder's history.  The container is only mounted while this is
+// being done.
+func (b *Builder) populate(options BuilderOptions) error {
+	mountPoint, err := b.store.Mount(b.ContainerID, "")
+	if err != nil {
+		return fmt.Errorf("error mounting build container: %v", err)
+	}
+	defer func() {
+		if err2 := b.store.Unmount(b.ContainerID); err2 != nil {
+			logrus.Errorf("error unmounting build container: %v", err2)
+		}
+	}()
+	if options.RootFS != "" {
+		logrus.Debugf("copying %q to %q", options.RootFS, mountPoint)
+		if err = newCopier().copyTree(options.RootFS, mountPoint); err != nil {
+			return fmt.Errorf("error copying %q to %q: %v", options.RootFS, mountPoint, err)
+		}
+		b.AddHistory(fmt.Sprintf("ADD dir:%s in /", options.RootFS), false)
+		return nil
+	}
+	logrus.Debugf("extracting contents of %q into %q", options.Tarball, mountPoint)
+	if err = archive.UntarPath(options.Tarball, mountPoint); err != nil {
+		return fmt.Errorf("error extracting %q into %q: %v", options.Tarball, mountPoint, err)
+	}
+	b.AddHistory(fmt.Sprintf("ADD file:%s in /", options.Tarball), false)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -39,7 +40,8 @@ func getExportOptions() generate.ExportOptions {
 	return generate.ExportOptions{}
 }
 
-// Run runs the specified command in the container's root filesystem.
+// Run runs the specified command in the container's root filesystem.  If the
+// command succeeds, the step is recorded in the Builder's history.
 func (b *Builder) Run(command []string, options RunOptions) error {
 	path, err := ioutil.TempDir(os.TempDir(), Package)
 	if err != nil {
@@ -71,12 +73,26 @@ func (b *Builder) Run(command []string, options RunOptions) error {
 	}
 	g.SetProcessUID(user.UID)
 	g.SetProcessGID(user.GID)
+	envNames := map[string]bool{}
 	for _, envSpec := range image.Config.Env {
 		env := strings.SplitN(envSpec, "=", 2)
 		if len(env) > 1 {
 			g.AddProcessEnv(env[0], env[1])
+			envNames[env[0]] = true
 		}
 	}
+	// Build-time variables are visible to the command, but environment
+	// variables which will be set in the image take precedence.
+	argNames := []string{}
+	for name := range b.Arg {
+		if !envNames[name] {
+			argNames = append(argNames, name)
+		}
+	}
+	sort.Strings(argNames)
+	for _, name := range argNames {
+		g.AddProcessEnv(name, b.Arg[name])
+	}
 	if len(command) > 0 {
 		g.SetProcessArgs(command)
 	} else if len(image.Config.Cmd) != 0 {
@@ -93,12 +109,14 @@ func (b *Builder) Run(command []string, options RunOptions) error {
 	for volume := range image.Config.Volumes {
 		g.AddTmpfsMount(volume, nil)
 	}
-	mountPoint, err := b.Mount("")
+	// Mount the container just for as long as we need it, without
+	// disturbing any links to its root if it's already mounted.
+	mountPoint, err := b.store.Mount(b.ContainerID, "")
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if err2 := b.Unmount(); err2 != nil {
+		if err2 := b.store.Unmount(b.ContainerID); err2 != nil {
 			logrus.Errorf("error unmounting container: %v", err2)
 		}
 	}()
@@ -144,6 +162,8 @@ func (b *Builder) Run(command []string, options RunOptions) error {
 	err = cmd.Run()
 	if err != nil {
 		logrus.Debugf("error running runc %v: %v", spec.Process.Args, err)
+		return err
 	}
-	return err
+	b.AddHistory("RUN "+strings.Join(spec.Process.Args, " "), false)
+	return b.Save()
 }
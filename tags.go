@@ -0,0 +1,90 @@
+package buildah
+
+import (
+	"fmt"
+	"strings"
+
+	ociLayout "github.com/containers/image/oci/layout"
+	is "github.com/containers/image/storage"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+	"github.com/containers/storage/storage"
+)
+
+// taggingReference wraps a reference to a location which can hold more than
+// one name for an image, so that once the image is written there, tag is
+// called to give it the rest of its names.
+type taggingReference struct {
+	types.ImageReference
+	tag func() error
+}
+
+// taggingDestination is the ImageDestination that taggingReference opens.
+type taggingDestination struct {
+	types.ImageDestination
+	tag func() error
+}
+
+func (r *taggingReference) NewImageDestination(sc *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := r.ImageReference.NewImageDestination(sc)
+	if err != nil {
+		return nil, err
+	}
+	return &taggingDestination{ImageDestination: dest, tag: r.tag}, nil
+}
+
+func (d *taggingDestination) Commit() error {
+	if err := d.ImageDestination.Commit(); err != nil {
+		return err
+	}
+	return d.tag()
+}
+
+// withAdditionalTags returns a reference which, when an image is written to
+// it, also gives the image each of the names in tags.  This is only possible
+// for destinations which can hold more than one name for an image: OCI
+// layouts and archives, docker-archive tarballs, and the Store.
+func withAdditionalTags(store storage.Store, dest types.ImageReference, tags []string) (types.ImageReference, error) {
+	if len(tags) == 0 {
+		return dest, nil
+	}
+	switch dest.Transport().Name() {
+	case OCIArchiveTransport.Name(), DockerArchiveTransport.Name():
+		archive := *(dest.(*archiveReference))
+		archive.tags = append(append([]string{}, archive.tags...), tags...)
+		return &archive, nil
+	case ociLayout.Transport.Name():
+		spec := dest.StringWithinTransport()
+		sep := strings.LastIndex(spec, ":")
+		if sep == -1 {
+			return nil, fmt.Errorf("error parsing OCI layout reference %q", spec)
+		}
+		dir, tag := spec[:sep], spec[sep+1:]
+		return &taggingReference{
+			ImageReference: dest,
+			tag: func() error {
+				return addOCILayoutTags(dir, tag, tags)
+			},
+		}, nil
+	case is.Transport.Name():
+		names := []string{}
+		for _, tag := range tags {
+			named, err := parseTaggedName(tag)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, named.Name()+":"+named.Tag())
+		}
+		return &taggingReference{
+			ImageReference: dest,
+			tag: func() error {
+				img, err := is.Transport.GetStoreImage(store, dest)
+				if err != nil {
+					return fmt.Errorf("error locating image %q: %v", transports.ImageName(dest), err)
+				}
+				return addImageNames(store, img, names...)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("can't give images written to %q additional tags", transports.ImageName(dest))
+}
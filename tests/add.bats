@@ -88,3 +88,117 @@ load helpers
 	cmp ${TESTDIR}/tarball3/tarball3.random2 $newroot/tarball3/tarball3.random2
 	buildah delete --name=$newcid
 }
+
+@test "add-git" {
+	mkdir -p ${TESTDIR}/checkout/subdir
+	createrandom ${TESTDIR}/checkout/randomfile
+	createrandom ${TESTDIR}/checkout/subdir/other-randomfile
+	git -C ${TESTDIR}/checkout init -q
+	git -C ${TESTDIR}/checkout add .
+	git -C ${TESTDIR}/checkout -c user.name=test -c user.email=test@example.com commit -q -m "test"
+	git -C ${TESTDIR}/checkout tag v1
+	git clone -q --bare ${TESTDIR}/checkout ${TESTDIR}/repo.git
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	buildah config --workingdir=/ --name=$cid
+	# Add the whole repository.
+	buildah add --name=$cid --dest=/whole file://${TESTDIR}/repo.git
+	# Add a subdirectory of a tagged revision.
+	buildah add --name=$cid --dest=/partial file://${TESTDIR}/repo.git#v1:subdir
+	buildah unmount --name=$cid
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image
+	buildah delete --name=$cid
+
+	newcid=$(buildah from --image new-image)
+	newroot=$(buildah mount --name=$newcid)
+	test ! -e $newroot/whole/.git
+	cmp ${TESTDIR}/checkout/randomfile $newroot/whole/randomfile
+	cmp ${TESTDIR}/checkout/subdir/other-randomfile $newroot/whole/subdir/other-randomfile
+	test ! -e $newroot/partial/randomfile
+	cmp ${TESTDIR}/checkout/subdir/other-randomfile $newroot/partial/other-randomfile
+	buildah delete --name=$newcid
+}
+
+@test "add-url-archive" {
+	mkdir -p ${TESTDIR}/serve ${TESTDIR}/tarball
+	createrandom ${TESTDIR}/tarball/random1
+	createrandom ${TESTDIR}/tarball/random2
+	tar -c -C ${TESTDIR} -z -f ${TESTDIR}/serve/tarball.tar.gz tarball
+	tar -c -C ${TESTDIR} -j -f ${TESTDIR}/serve/tarball.tar.bz2 tarball
+	tar -c -C ${TESTDIR} -J -f ${TESTDIR}/serve/tarball.tar.xz tarball
+	echo '{"compressed": true}' | gzip > ${TESTDIR}/serve/data.json.gz
+	starthttpd ${TESTDIR}/serve
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	# Without the flag, the tarball is just saved.
+	buildah add --name=$cid --dest=/saved http://127.0.0.1:${HTTP_PORT}/tarball.tar.gz
+	# With it, each kind of tarball is extracted.
+	buildah add --name=$cid --extract-urls --dest=/gzip http://127.0.0.1:${HTTP_PORT}/tarball.tar.gz
+	buildah add --name=$cid --extract-urls --dest=/bzip2 http://127.0.0.1:${HTTP_PORT}/tarball.tar.bz2
+	buildah add --name=$cid --extract-urls --dest=/xz http://127.0.0.1:${HTTP_PORT}/tarball.tar.xz
+	# Compressed files which aren't tarballs are saved.
+	buildah add --name=$cid --extract-urls --dest=/json http://127.0.0.1:${HTTP_PORT}/data.json.gz
+	cmp ${TESTDIR}/serve/tarball.tar.gz $root/saved/tarball.tar.gz
+	cmp ${TESTDIR}/serve/data.json.gz $root/json/data.json.gz
+	for format in gzip bzip2 xz ; do
+		cmp ${TESTDIR}/tarball/random1 $root/$format/tarball/random1
+		cmp ${TESTDIR}/tarball/random2 $root/$format/tarball/random2
+	done
+	buildah unmount --name=$cid
+	buildah delete --name=$cid
+}
+
+@test "add-url-auth" {
+	mkdir -p ${TESTDIR}/serve
+	createrandom ${TESTDIR}/serve/randomfile
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+
+	startauthhttpd ${TESTDIR}/serve "Bearer secret-token"
+	run buildah add --name=$cid --dest=/none http://127.0.0.1:${HTTP_PORT}/randomfile
+	[ "$status" -ne 0 ]
+	buildah add --name=$cid --dest=/bearer --bearer-token=secret-token http://127.0.0.1:${HTTP_PORT}/randomfile
+	cmp ${TESTDIR}/serve/randomfile $root/bearer/randomfile
+	buildah add --name=$cid --dest=/header --header "Authorization: Bearer secret-token" http://127.0.0.1:${HTTP_PORT}/randomfile
+	cmp ${TESTDIR}/serve/randomfile $root/header/randomfile
+	stophttpd
+
+	startauthhttpd ${TESTDIR}/serve "Basic $(echo -n user:pass | base64)"
+	run buildah add --name=$cid --dest=/wrong --creds=user:wrong http://127.0.0.1:${HTTP_PORT}/randomfile
+	[ "$status" -ne 0 ]
+	buildah add --name=$cid --dest=/basic --creds=user:pass --timeout=30s --retries=2 --retry-delay=100ms http://127.0.0.1:${HTTP_PORT}/randomfile
+	cmp ${TESTDIR}/serve/randomfile $root/basic/randomfile
+	stophttpd
+
+	buildah unmount --name=$cid
+	buildah delete --name=$cid
+}
+
+@test "add-git-submodules" {
+	gitc() {
+		git -c user.name=test -c user.email=test@example.com -c protocol.file.allow=always "$@"
+	}
+	mkdir -p ${TESTDIR}/module ${TESTDIR}/checkout
+	createrandom ${TESTDIR}/module/randomfile
+	gitc -C ${TESTDIR}/module init -q
+	gitc -C ${TESTDIR}/module add .
+	gitc -C ${TESTDIR}/module commit -q -m "module"
+	gitc -C ${TESTDIR}/checkout init -q
+	gitc -C ${TESTDIR}/checkout submodule -q add ${TESTDIR}/module module
+	gitc -C ${TESTDIR}/checkout commit -q -m "test"
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	GIT_CONFIG_COUNT=1 GIT_CONFIG_KEY_0=protocol.file.allow GIT_CONFIG_VALUE_0=always buildah add --name=$cid --dest=/whole file://${TESTDIR}/checkout
+	cmp ${TESTDIR}/module/randomfile $root/whole/module/randomfile
+	test ! -e $root/whole/.git
+	test ! -e $root/whole/module/.git
+	# Refs which look like options are rejected.
+	run buildah add --name=$cid --dest=/bad "file://${TESTDIR}/checkout#--help"
+	[ "$status" -ne 0 ]
+	buildah unmount --name=$cid
+	buildah delete --name=$cid
+}
@@ -56,3 +56,243 @@ load helpers
 	cmp ${TESTDIR}/other-randomfile $othernewroot/other-randomfile
 	buildah delete --name=$othernewcid
 }
+
+@test "commit-reproducible" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah copy --name=$cid ${TESTDIR}/randomfile /randomfile
+	buildah config --name=$cid --env FOO=bar
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --timestamp 0 --output=dir:${TESTDIR}/image
+	sleep 1
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --timestamp 0 --output=dir:${TESTDIR}/other-image
+	cmp ${TESTDIR}/image/manifest.json ${TESTDIR}/other-image/manifest.json
+	[ "$(dirconfig ${TESTDIR}/image created)" = '"1970-01-01T00:00:00Z"' ]
+	[ "$(dirconfig ${TESTDIR}/image history -1 created)" = '"1970-01-01T00:00:00Z"' ]
+	SOURCE_DATE_EPOCH=0 buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/env-image
+	cmp ${TESTDIR}/image/manifest.json ${TESTDIR}/env-image/manifest.json
+	buildah delete --name=$cid
+	# The layers that we got from alpine are left as they were.
+	cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/alpine-image
+	buildah delete --name=$cid
+	base=$(dirconfig ${TESTDIR}/alpine-image rootfs diff_ids | python3 -c 'import json, sys; print(json.dumps(json.load(sys.stdin)[:-1]))')
+	[ "$(dirconfig ${TESTDIR}/image rootfs diff_ids | python3 -c 'import json, sys; print(json.dumps(json.load(sys.stdin)[:-1]))')" = "$base" ]
+}
+
+@test "commit-squash" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	cp ${TESTDIR}/randomfile $root/randomfile
+	rm -f $root/etc/motd
+	buildah unmount --name=$cid
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --squash --output=dir:${TESTDIR}/image
+	layers=$(python3 -c 'import json, sys; print(" ".join(l["digest"].split(":")[1] for l in json.load(open(sys.argv[1]))["layers"]))' ${TESTDIR}/image/manifest.json)
+	[ $(echo $layers | wc -w) -eq 1 ]
+	[ "$(dirconfig ${TESTDIR}/image rootfs diff_ids | python3 -c 'import json, sys; print(len(json.load(sys.stdin)))')" -eq 1 ]
+	[ "$(dirconfig ${TESTDIR}/image history | python3 -c 'import json, sys; print(len([h for h in json.load(sys.stdin) if not h.get("empty_layer")]))')" -eq 1 ]
+	tar tzf ${TESTDIR}/image/${layers}.tar > ${TESTDIR}/contents
+	grep -q '^\(\./\)\?randomfile$' ${TESTDIR}/contents
+	grep -q '^\(\./\)\?bin/busybox$' ${TESTDIR}/contents
+	! grep -q 'motd' ${TESTDIR}/contents
+	! grep -q '\.wh\.' ${TESTDIR}/contents
+	buildah delete --name=$cid
+}
+
+@test "commit-store-layer" {
+	createrandom ${TESTDIR}/randomfile
+	createrandom ${TESTDIR}/other-randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/base-image
+	buildah copy --name=$cid ${TESTDIR}/randomfile /randomfile
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --store-layer --output=dir:${TESTDIR}/image
+	buildah copy --name=$cid ${TESTDIR}/other-randomfile /other-randomfile
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --store-layer --output=dir:${TESTDIR}/other-image
+
+	layers() {
+		python3 -c 'import json, sys; print(" ".join(l["digest"] for l in json.load(open(sys.argv[1]))["layers"]))' $1/manifest.json
+	}
+	nonempty() {
+		dirconfig $1 history | python3 -c 'import json, sys; print(len([h for h in json.load(sys.stdin) if not h.get("empty_layer")]))'
+	}
+	baselayers=$(layers ${TESTDIR}/base-image | wc -w)
+	# The base image carried an empty layer for the container, which we
+	# don't have to write any more.
+	[ $(layers ${TESTDIR}/image | wc -w) -eq $baselayers ]
+	[ $(layers ${TESTDIR}/other-image | wc -w) -eq $((baselayers+1)) ]
+	[ $(nonempty ${TESTDIR}/other-image) -eq $((baselayers+1)) ]
+	# The layer from the first commit is reused as-is by the second one.
+	[ "$(layers ${TESTDIR}/image)" = "$(layers ${TESTDIR}/other-image | cut -d' ' -f1-$baselayers)" ]
+	# The stored layers' blobs are kept, and aren't generated again.
+	run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/third-image
+	[ "$status" -eq 0 ]
+	[ $(echo "$output" | grep -c 'reusing blob') -eq 2 ]
+	[ "$(layers ${TESTDIR}/other-image)" = "$(layers ${TESTDIR}/third-image | cut -d' ' -f1-$((baselayers+1)))" ]
+
+	# The container's name still works, and it has everything in it.
+	root=$(buildah mount --name=$cid)
+	cmp ${TESTDIR}/randomfile $root/randomfile
+	cmp ${TESTDIR}/other-randomfile $root/other-randomfile
+	buildah unmount --name=$cid
+	buildah delete --name=$cid
+	# The images which held the stored layers went away with it.
+	python3 - ${TESTDIR}/root/vfs-images/images.json <<- _EOF
+		import json, sys
+		images = json.load(open(sys.argv[1]))
+		assert all(image.get("names") for image in images), images
+	_EOF
+}
+
+@test "commit-compression" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	mediatypes() {
+		python3 -c 'import json, sys; print(" ".join(sorted(set(l["mediaType"] for l in json.load(open(sys.argv[1]))["layers"]))))' $1/manifest.json
+	}
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression none --output=dir:${TESTDIR}/none
+	[ "$(mediatypes ${TESTDIR}/none)" = "application/vnd.oci.image.layer.v1.tar" ]
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression gzip --compression-level 9 --output=dir:${TESTDIR}/gzip
+	[ "$(mediatypes ${TESTDIR}/gzip)" = "application/vnd.oci.image.layer.v1.tar+gzip" ]
+	# The diff IDs are the digests of the uncompressed layers.
+	[ "$(dirconfig ${TESTDIR}/none rootfs diff_ids)" = "$(dirconfig ${TESTDIR}/gzip rootfs diff_ids)" ]
+	# Level 0 asks for gzip without any compression, so it's bigger.
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression gzip --compression-level 0 --output=dir:${TESTDIR}/gzip0
+	[ "$(mediatypes ${TESTDIR}/gzip0)" = "application/vnd.oci.image.layer.v1.tar+gzip" ]
+	layersize() {
+		python3 -c 'import json, sys; print(sum(l["size"] for l in json.load(open(sys.argv[1]))["layers"]))' $1/manifest.json
+	}
+	[ $(layersize ${TESTDIR}/gzip0) -gt $(layersize ${TESTDIR}/none) ]
+	for compression in bzip2 xz ; do
+		run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression $compression --output=dir:${TESTDIR}/$compression
+		[ "$status" -ne 0 ]
+		[[ "$output" =~ "can not be described" ]]
+	done
+	run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression lzma --output=dir:${TESTDIR}/lzma
+	[ "$status" -ne 0 ]
+	# Docker v2 schema 2 manifests have no way to describe uncompressed layers.
+	run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression none --output=docker-archive:${TESTDIR}/none.tar
+	[ "$status" -ne 0 ]
+	[[ "$output" =~ "can not be described" ]]
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --compression gzip --output=docker-archive:${TESTDIR}/gzip.tar
+	buildah delete --name=$cid
+}
+
+@test "commit-jobs" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --timestamp 0 --jobs 1 --output=dir:${TESTDIR}/serial
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --timestamp 0 --jobs 8 --output=dir:${TESTDIR}/parallel
+	# The layers are listed in the same order, no matter how many were
+	# compressed at once.
+	cmp ${TESTDIR}/serial/manifest.json ${TESTDIR}/parallel/manifest.json
+	buildah delete --name=$cid
+}
+
+@test "from-arch" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/host-image
+	buildah delete --name=$cid
+	cid=$(buildah from --pull-always --signature-policy ${TESTSDIR}/policy.json --arch arm64 --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/arm64-image
+	[ "$(dirconfig ${TESTDIR}/arm64-image architecture)" = '"arm64"' ]
+	buildah delete --name=$cid
+	# The image for the platform we're running on keeps its name.
+	cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/other-host-image
+	[ "$(dirconfig ${TESTDIR}/other-host-image architecture)" = "$(dirconfig ${TESTDIR}/host-image architecture)" ]
+	buildah delete --name=$cid
+	# The arm64 image can be found again without pulling it.
+	cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json --arch arm64 --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/other-arm64-image
+	[ "$(dirconfig ${TESTDIR}/other-arm64-image architecture)" = '"arm64"' ]
+	buildah delete --name=$cid
+	# Pulling it again reuses the copy we already have.
+	cid=$(buildah from --pull-always --signature-policy ${TESTSDIR}/policy.json --arch arm64 --image alpine)
+	buildah delete --name=$cid
+	# Neither of them is used for other platforms.
+	run buildah from --signature-policy ${TESTSDIR}/policy.json --arch ppc64le --image alpine
+	[ "$status" -ne 0 ]
+
+	cid=$(buildah from --arch s390x --image scratch)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/scratch-image
+	[ "$(dirconfig ${TESTDIR}/scratch-image architecture)" = '"s390x"' ]
+	buildah delete --name=$cid
+}
+
+@test "from-rootfs" {
+	mkdir -p ${TESTDIR}/rootfs/etc ${TESTDIR}/rootfs/bin
+	createrandom ${TESTDIR}/rootfs/etc/randomfile
+	chown 1:2 ${TESTDIR}/rootfs/etc/randomfile
+	tar -C ${TESTDIR}/rootfs -czf ${TESTDIR}/rootfs.tar.gz .
+
+	cid=$(buildah from --rootfs ${TESTDIR}/rootfs)
+	root=$(buildah mount --name=$cid)
+	cmp ${TESTDIR}/rootfs/etc/randomfile $root/etc/randomfile
+	[ "$(stat -c %u:%g $root/etc/randomfile)" = 1:2 ]
+	buildah unmount --name=$cid
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/dir
+	[ "$(dirconfig ${TESTDIR}/dir os)" = '"linux"' ]
+	buildah delete --name=$cid
+
+	buildah from --tarball ${TESTDIR}/rootfs.tar.gz --mount > ${TESTDIR}/from.txt
+	cid=$(sed -n 1p ${TESTDIR}/from.txt)
+	root=$(sed -n 2p ${TESTDIR}/from.txt)
+	cmp ${TESTDIR}/rootfs/etc/randomfile $root/etc/randomfile
+	[ "$(stat -c %u:%g $root/etc/randomfile)" = 1:2 ]
+	# Unmounting once is enough to really unmount it.
+	buildah unmount --name=$cid
+	python3 - ${TESTDIR}/runroot/vfs-layers/mountpoints.json <<- _EOF
+		import json, sys
+		mounts = json.load(open(sys.argv[1]))
+		assert all(mount.get("count", 0) == 0 for mount in mounts), mounts
+	_EOF
+	buildah delete --name=$cid
+
+	run buildah from --rootfs ${TESTDIR}/rootfs --tarball ${TESTDIR}/rootfs.tar.gz
+	[ "$status" -ne 0 ]
+	run buildah from --image alpine --rootfs ${TESTDIR}/rootfs
+	[ "$status" -ne 0 ]
+}
+
+@test "export" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	cp ${TESTDIR}/randomfile $root/randomfile
+	buildah export --name=$cid --output=${TESTDIR}/rootfs.tar
+	tar -tf ${TESTDIR}/rootfs.tar | grep -q '^etc/os-release$'
+	tar -xOf ${TESTDIR}/rootfs.tar randomfile | cmp - ${TESTDIR}/randomfile
+	buildah unmount --name=$cid
+	buildah export --name=$cid --compression=gzip --output=- > ${TESTDIR}/rootfs.tar.gz
+	tar -xzOf ${TESTDIR}/rootfs.tar.gz randomfile | cmp - ${TESTDIR}/randomfile
+	buildah export $cid -o ${TESTDIR}/positional.tar
+	tar -xOf ${TESTDIR}/positional.tar randomfile | cmp - ${TESTDIR}/randomfile
+	run buildah export --name=$cid
+	[ "$status" -ne 0 ]
+	for compression in bzip2 xz ; do
+		run buildah export --name=$cid --compression=$compression --output=${TESTDIR}/rootfs.tar.$compression
+		[ "$status" -ne 0 ]
+		[[ "$output" =~ "--compression" ]]
+		[ ! -e ${TESTDIR}/rootfs.tar.$compression ]
+	done
+	buildah delete --name=$cid
+}
+
+@test "diff" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	mkdir -p $root/var/cache/stray
+	cp ${TESTDIR}/randomfile $root/var/cache/stray/randomfile
+	rm $root/etc/os-release
+	buildah unmount --name=$cid
+	buildah diff --name=$cid > ${TESTDIR}/diff.txt
+	grep -q "^A *256 /var/cache/stray/randomfile\$" ${TESTDIR}/diff.txt
+	grep -q "^D *0 /etc/os-release\$" ${TESTDIR}/diff.txt
+	buildah diff --name=$cid --json /var/cache > ${TESTDIR}/diff.json
+	python3 - ${TESTDIR}/diff.json <<- _EOF
+		import json, sys
+		changes = json.load(open(sys.argv[1]))
+		assert {"kind": "A", "path": "/var/cache/stray/randomfile", "size": 256} in changes, changes
+		assert all(c["path"].startswith("/var/cache") for c in changes), changes
+	_EOF
+	buildah delete --name=$cid
+}
@@ -0,0 +1,63 @@
+#!/usr/bin/env bats
+
+load helpers
+
+@test "config-replace-and-unset" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --env PATH=/usr/local/bin:/usr/bin:/bin --env FOO=1 --env BAR=1 --label a=1 --label b=1 --volume /vol1 --volume /vol2 --port 80 --port 443 --cmd "sh -c true" --entrypoint "/bin/sh"
+	# Replace, rather than append, a variable that was set in the image
+	# and one that we set ourselves.
+	buildah config --name=$cid --env PATH=/bin --env FOO=2
+	buildah config --name=$cid --unset-env BAR --unset-label b --unset-volume /vol2 --unset-port 443 --clear-cmd --clear-entrypoint
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/image
+	[ "$(dirconfig ${TESTDIR}/image config Env)" = '["PATH=/bin","FOO=2"]' ]
+	[ "$(dirconfig ${TESTDIR}/image config labels)" = '{"a":"1"}' ]
+	[ "$(dirconfig ${TESTDIR}/image config Volumes)" = '{"/vol1":{}}' ]
+	[ "$(dirconfig ${TESTDIR}/image config ExposedPorts)" = '{"80":{}}' ]
+	[ "$(dirconfig ${TESTDIR}/image config Cmd)" = 'null' ]
+	[ "$(dirconfig ${TESTDIR}/image config Entrypoint)" = 'null' ]
+
+	# Setting a value again after unsetting it should bring it back.
+	buildah config --name=$cid --env BAR=3 --label b=3 --cmd "sh"
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/other-image
+	[ "$(dirconfig ${TESTDIR}/other-image config Env)" = '["PATH=/bin","FOO=2","BAR=3"]' ]
+	[ "$(dirconfig ${TESTDIR}/other-image config labels)" = '{"a":"1","b":"3"}' ]
+	[ "$(dirconfig ${TESTDIR}/other-image config Cmd)" = '["sh"]' ]
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:labeled-image
+	buildah delete --name=$cid
+
+	# A label without a value, or followed by "-", is removed, even if it
+	# came from the source image.
+	cid=$(buildah from --image labeled-image)
+	buildah config --name=$cid --label a --label b- --label c=4
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/third-image
+	[ "$(dirconfig ${TESTDIR}/third-image config labels)" = '{"c":"4"}' ]
+	buildah delete --name=$cid
+}
+
+@test "config-docker-only-fields" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --healthcheck "test -e /tmp" --healthcheck-interval 30s --healthcheck-retries 3 --stop-signal SIGINT --shell "/bin/ash -c" --onbuild "RUN echo hello" --onbuild "ENV A=b"
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/image
+	[ "$(dirconfig ${TESTDIR}/image config Healthcheck)" = '{"Interval":30000000000,"Retries":3,"Test":["CMD-SHELL","test -e /tmp"]}' ]
+	[ "$(dirconfig ${TESTDIR}/image config StopSignal)" = '"SIGINT"' ]
+	[ "$(dirconfig ${TESTDIR}/image config Shell)" = '["/bin/ash","-c"]' ]
+	[ "$(dirconfig ${TESTDIR}/image config OnBuild)" = '["RUN echo hello","ENV A=b"]' ]
+	buildah delete --name=$cid
+}
+
+@test "config-build-arg" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --build-arg VERSION=1.2 --build-arg DIR=/opt
+	buildah config --name=$cid --workingdir '${DIR}/app-$VERSION' --env 'APP_VERSION=$VERSION' --env 'LEVEL=${LEVEL:-info}' --label 'version=${APP_VERSION}' --label 'literal=\$VERSION'
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/image
+	[ "$(dirconfig ${TESTDIR}/image config WorkingDir)" = '"/opt/app-1.2"' ]
+	[ "$(dirconfig ${TESTDIR}/image config Env)" = '["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin","APP_VERSION=1.2","LEVEL=info"]' ]
+	[ "$(dirconfig ${TESTDIR}/image config labels)" = '{"literal":"$VERSION","version":"1.2"}' ]
+	if which runc > /dev/null 2> /dev/null ; then
+		run buildah run --name=$cid --build-arg EXTRA=yes -- sh -c 'echo $VERSION $EXTRA'
+		[ "$status" -eq 0 ]
+		[ "$output" = "1.2 yes" ]
+	fi
+	buildah delete --name=$cid
+}
@@ -20,3 +20,42 @@ load helpers
 	cmp ${TESTDIR}/randomfile $newroot/randomfile
 	buildah delete --name=$newcid
 }
+
+@test "copy-from" {
+	createrandom ${TESTDIR}/randomfile
+	createrandom ${TESTDIR}/other-randomfile
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	buildah config --name=$cid --workingdir /
+	buildah copy --name=$cid ${TESTDIR}/randomfile
+	ln -s /randomfile $root/randomlink
+	buildah unmount --name=$cid
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image
+
+	othercid=$(buildah from --image alpine)
+	othercontainer=$(buildah from --image alpine)
+	otherroot=$(buildah mount --name=$othercontainer)
+	cp ${TESTDIR}/other-randomfile $otherroot/other-randomfile
+	buildah unmount --name=$othercontainer
+	otherroot=$(buildah mount --name=$othercid)
+	# Copy from an image, following a link inside of the image.
+	buildah copy --name=$othercid --from=new-image --dest=/from-image /randomfile
+	buildah copy --name=$othercid --from=new-image --dest=/from-link /randomlink
+	# The temporary containers used for reading the image are gone.
+	[ "$(python3 -c 'import json, sys; print(len(json.load(open(sys.argv[1]))))' ${TESTDIR}/root/vfs-containers/containers.json)" -eq 3 ]
+	# Copy from a working container which isn't mounted.
+	buildah copy --name=$othercid --from=$othercontainer --dest=/from-container /other-randomfile
+	# Copy from a working container which is mounted, and should stay mounted.
+	root=$(buildah mount --name=$cid)
+	buildah copy --name=$othercid --from=$cid --dest=/from-mounted /randomfile
+	test -s $root/randomfile
+	cmp ${TESTDIR}/randomfile $otherroot/from-image/randomfile
+	cmp ${TESTDIR}/randomfile $otherroot/from-link/randomfile
+	cmp ${TESTDIR}/other-randomfile $otherroot/from-container/other-randomfile
+	cmp ${TESTDIR}/randomfile $otherroot/from-mounted/randomfile
+	buildah unmount --name=$cid
+	buildah delete --name=$cid
+	buildah delete --name=$othercid
+	buildah delete --name=$othercontainer
+}
@@ -12,6 +12,7 @@ function setup() {
 }
 
 function teardown() {
+	stophttpd
 	rm -fr ${TESTDIR}
 }
 
@@ -22,3 +23,60 @@ function createrandom() {
 function buildah() {
 	${BUILDAH_BINARY} --debug --root ${TESTDIR}/root --runroot ${TESTDIR}/runroot --storage-driver vfs "$@"
 }
+
+function starthttpd() {
+	pushd ${1:-${TESTDIR}} > /dev/null
+	HTTP_PORT=$(( 10000 + RANDOM % 20000 ))
+	python3 -m http.server --bind 127.0.0.1 ${HTTP_PORT} > /dev/null 2>&1 &
+	HTTP_PID=$!
+	popd > /dev/null
+	for i in $(seq 50) ; do
+		curl -s -o /dev/null http://127.0.0.1:${HTTP_PORT}/ && break
+		sleep 0.1
+	done
+}
+
+function stophttpd() {
+	if test -n "${HTTP_PID}" ; then
+		kill ${HTTP_PID}
+		wait ${HTTP_PID} || true
+		HTTP_PID=
+	fi
+}
+
+function startauthhttpd() {
+	# Serve files from a directory, but only to clients which send the
+	# expected Authorization header.
+	HTTP_PORT=$(( 10000 + RANDOM % 20000 ))
+	python3 - ${1:-${TESTDIR}} ${HTTP_PORT} "$2" > /dev/null 2>&1 <<- _EOF &
+		import functools, http.server, sys
+		class Handler(http.server.SimpleHTTPRequestHandler):
+		    def do_GET(self):
+		        if self.headers.get("Authorization") != sys.argv[3]:
+		            self.send_error(401)
+		            return
+		        http.server.SimpleHTTPRequestHandler.do_GET(self)
+		handler = functools.partial(Handler, directory=sys.argv[1])
+		http.server.HTTPServer(("127.0.0.1", int(sys.argv[2])), handler).serve_forever()
+	_EOF
+	HTTP_PID=$!
+	for i in $(seq 50) ; do
+		curl -s -o /dev/null http://127.0.0.1:${HTTP_PORT}/ && break
+		sleep 0.1
+	done
+}
+
+function dirconfig() {
+	# Print a value from the configuration blob of an image which was
+	# written using the dir: transport, given the path to the image and a
+	# list of keys, compactly, as JSON.
+	python3 - "$@" <<- _EOF
+		import json, sys
+		manifest = json.load(open(sys.argv[1] + "/manifest.json"))
+		digest = manifest["config"]["digest"].split(":")[1]
+		value = json.load(open(sys.argv[1] + "/" + digest + ".tar"))
+		for key in sys.argv[2:]:
+		    value = value.get(key) if isinstance(value, dict) else value[int(key)]
+		print(json.dumps(value, sort_keys=True, separators=(",", ":")))
+	_EOF
+}
@@ -0,0 +1,56 @@
+#!/usr/bin/env bats
+
+load helpers
+
+@test "commit-history" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/base-image
+	baselength=$(dirconfig ${TESTDIR}/base-image history | python3 -c 'import json, sys; print(len(json.load(sys.stdin)))')
+
+	buildah config --name=$cid --env FOO=bar --label a=b
+	buildah copy --name=$cid ${TESTDIR}/randomfile /randomfile
+	buildah config --name=$cid --workingdir /tmp
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/image
+	# The base image's history was one entry longer, for the layer that
+	# was committed without any recorded steps.
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength-1)) created_by)" = '"ENV FOO=bar"' ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength-1)) empty_layer)" = 'true' ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength)) created_by)" = '"LABEL a=b"' ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength+1)) created_by)" = "\"COPY ${TESTDIR}/randomfile /randomfile\"" ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength+1)) empty_layer)" = 'null' ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength+2)) created_by)" = '"WORKDIR /tmp"' ]
+	[ "$(dirconfig ${TESTDIR}/image history $((baselength+2)) empty_layer)" = 'true' ]
+	[ "$(dirconfig ${TESTDIR}/image history | python3 -c 'import json, sys; print(len(json.load(sys.stdin)))')" -eq $((baselength+3)) ]
+	buildah delete --name=$cid
+}
+
+@test "history" {
+	createrandom ${TESTDIR}/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --env FOO=bar
+	buildah copy --name=$cid ${TESTDIR}/randomfile /randomfile
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image
+	buildah delete --name=$cid
+
+	buildah history --image new-image | grep -q "ENV FOO=bar"
+	buildah history --image new-image --format '{{.CreatedBy}}' | head -n 1 | grep -q "^COPY ${TESTDIR}/randomfile /randomfile\$"
+	[ "$(buildah history --image new-image --quiet | wc -l)" -eq "$(buildah history --image alpine --quiet | wc -l | xargs expr 1 +)" ]
+	buildah history --image new-image --quiet --no-trunc | grep -q '^sha256:'
+	buildah history --image new-image --format json > ${TESTDIR}/history.json
+	python3 - ${TESTDIR}/history.json <<- _EOF
+		import json, sys
+		history = json.load(open(sys.argv[1]))
+		assert history[0]["created-by"].startswith("COPY "), history
+		assert history[0]["layer"].startswith("sha256:"), history
+		assert history[0]["size"] > 256, history
+		assert history[1]["created-by"] == "ENV FOO=bar", history
+		assert history[1]["empty-layer"], history
+		assert "layer" not in history[1], history
+	_EOF
+	buildah history new-image --format '{{.CreatedBy}}' | head -n 1 | grep -q "^COPY ${TESTDIR}/randomfile /randomfile\$"
+	run buildah history --image no-such-image
+	[ "$status" -ne 0 ]
+	run buildah history
+	[ "$status" -ne 0 ]
+}
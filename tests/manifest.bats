@@ -0,0 +1,64 @@
+#!/usr/bin/env bats
+
+load helpers
+
+@test "manifest-list" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:image-a
+	buildah config --name=$cid --label variant=b
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:image-b
+	buildah delete --name=$cid
+
+	buildah manifest create --list=mylist
+	run buildah manifest create --list=mylist
+	[ "$status" -ne 0 ]
+	digesta=$(buildah manifest add --list=mylist --image=image-a)
+	digestb=$(buildah manifest add --list=mylist --image=image-b --arch=arm64 --variant=v8)
+	[ "$digesta" != "$digestb" ]
+	buildah manifest annotate --list=mylist --image=$digesta --annotation foo=bar
+
+	buildah manifest inspect --list=mylist > ${TESTDIR}/list.json
+	python3 - ${TESTDIR}/list.json "$digesta" "$digestb" <<- _EOF
+		import json, sys
+		index = json.load(open(sys.argv[1]))
+		assert index["mediaType"] == "application/vnd.oci.image.manifest.list.v1+json", index
+		assert [m["digest"] for m in index["manifests"]] == sys.argv[2:4], index
+		assert index["manifests"][0]["platform"]["os"] == "linux", index
+		assert index["manifests"][0]["annotations"] == {"foo": "bar"}, index
+		assert index["manifests"][1]["platform"]["architecture"] == "arm64", index
+		assert index["manifests"][1]["platform"]["variant"] == "v8", index
+	_EOF
+
+	# Destinations which can only hold one manifest are rejected.
+	run buildah manifest push --signature-policy ${TESTSDIR}/policy.json --list=mylist --output=dir:${TESTDIR}/dir
+	[ "$status" -ne 0 ]
+	buildah manifest push --signature-policy ${TESTSDIR}/policy.json --list=mylist --format=docker --output=oci:${TESTDIR}/pushed:list
+	python3 - ${TESTDIR}/pushed <<- _EOF
+		import hashlib, json, os, sys
+		def blob(digest):
+		    algorithm, hex = digest.split(":")
+		    data = open(os.path.join(sys.argv[1], "blobs", algorithm, hex), "rb").read()
+		    assert hashlib.new(algorithm, data).hexdigest() == hex, digest
+		    return json.loads(data)
+		ref = json.load(open(os.path.join(sys.argv[1], "refs", "list")))
+		lst = blob(ref["digest"])
+		assert lst["mediaType"] == "application/vnd.docker.distribution.manifest.list.v2+json", lst
+		assert ref["mediaType"] == lst["mediaType"], ref
+		assert len(lst["manifests"]) == 2, lst
+		assert "annotations" not in lst["manifests"][0], lst
+		assert lst["manifests"][1]["platform"]["architecture"] == "arm64", lst
+		# Every image that the list points to is there, converted to
+		# match the list's format.
+		for m in lst["manifests"]:
+		    assert m["mediaType"] == "application/vnd.docker.distribution.manifest.v2+json", m
+		    image = blob(m["digest"])
+		    assert image["mediaType"] == m["mediaType"], image
+		    blob(image["config"]["digest"])
+		    for layer in image["layers"]:
+		        assert os.path.exists(os.path.join(sys.argv[1], "blobs", *layer["digest"].split(":"))), layer
+		assert len(set(m["digest"] for m in lst["manifests"])) == 2, lst
+	_EOF
+
+	run buildah manifest add --list=nosuchlist --image=image-a
+	[ "$status" -ne 0 ]
+}
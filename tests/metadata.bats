@@ -0,0 +1,67 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function metadata() {
+	# Print the metadata that we expect to be preserved for each item
+	# under a directory, in a format that we can compare.
+	(cd $1 && find . -mindepth 1 | sort | while read item ; do
+		stat -c "%n %F %a %u %g %t %T %h" "$item"
+		getfattr -h -d -m '^(user\..*|security\.capability)$' --absolute-names "$item" 2> /dev/null | grep -v "^#" | grep -v "^$" | sort
+	done)
+}
+
+function populate() {
+	mkdir -p $1/subdir
+	createrandom $1/file
+	createrandom $1/other-file
+	ln $1/file $1/subdir/hardlink
+	truncate -s 16M $1/sparse
+	echo marker | dd of=$1/sparse bs=1M seek=8 conv=notrunc status=none
+	mknod $1/null c 1 3
+	mkfifo $1/fifo
+	ln -s file $1/symlink
+	chown 1:2 $1/other-file
+	setfattr -n user.test -v value $1/other-file
+	setcap cap_net_raw+ep $1/file
+}
+
+@test "metadata-copy-and-commit" {
+	for tool in getfattr setfattr setcap ; do
+		if ! which $tool > /dev/null 2> /dev/null ; then
+			skip "$tool is not installed"
+		fi
+	done
+	populate ${TESTDIR}/tree
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	root=$(buildah mount --name=$cid)
+	buildah copy --name=$cid --dest=/ ${TESTDIR}/tree
+	# Compare what we copied.
+	metadata ${TESTDIR}/tree > ${TESTDIR}/before
+	metadata $root/tree > ${TESTDIR}/after
+	diff -u ${TESTDIR}/before ${TESTDIR}/after
+	# Holes in the sparse file should have been preserved.
+	test $(du -k $root/tree/sparse | cut -f1) -lt 1024
+	buildah unmount --name=$cid
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image
+	buildah delete --name=$cid
+
+	# Compare what we got back out of the image.
+	newcid=$(buildah from --image new-image)
+	newroot=$(buildah mount --name=$newcid)
+	metadata $newroot/tree > ${TESTDIR}/committed
+	diff -u ${TESTDIR}/before ${TESTDIR}/committed
+	cmp ${TESTDIR}/tree/sparse $newroot/tree/sparse
+	buildah delete --name=$newcid
+}
+
+@test "metadata-base-layers-unchanged" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah push --signature-policy ${TESTSDIR}/policy.json --image alpine --output=dir:${TESTDIR}/base
+	createrandom ${TESTDIR}/randomfile
+	buildah copy --name=$cid ${TESTDIR}/randomfile /randomfile
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/image
+	buildah delete --name=$cid
+	[ "$(dirconfig ${TESTDIR}/image rootfs diff_ids 0)" = "$(dirconfig ${TESTDIR}/base rootfs diff_ids 0)" ]
+}
@@ -0,0 +1,66 @@
+#!/usr/bin/env bats
+
+load helpers
+
+@test "from-onbuild" {
+	mkdir -p ${TESTDIR}/context
+	createrandom ${TESTDIR}/context/randomfile
+
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --onbuild "WORKDIR /app" --onbuild "COPY randomfile ." --onbuild "ENV FROM_ONBUILD=1" --onbuild "LABEL onbuild=yes"
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:onbuild-image
+	buildah delete --name=$cid
+
+	# Listing the triggers shouldn't run them.
+	run buildah from --image onbuild-image --list-onbuild
+	[ "$status" -eq 0 ]
+	[ "${#lines[@]}" -eq 5 ]
+	[ "${lines[1]}" = "WORKDIR /app" ]
+	[ "${lines[2]}" = "COPY randomfile ." ]
+	buildah delete --name=${lines[0]}
+
+	newcid=$(buildah from --image onbuild-image --run-onbuild --context ${TESTDIR}/context)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$newcid --output=dir:${TESTDIR}/image
+	[ "$(dirconfig ${TESTDIR}/image config WorkingDir)" = '"/app"' ]
+	[ "$(dirconfig ${TESTDIR}/image config Env)" = '["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin","FROM_ONBUILD=1"]' ]
+	[ "$(dirconfig ${TESTDIR}/image config labels)" = '{"onbuild":"yes"}' ]
+	# The triggers are consumed, and not passed along.
+	[ "$(dirconfig ${TESTDIR}/image config OnBuild)" = 'null' ]
+	root=$(buildah mount --name=$newcid)
+	cmp ${TESTDIR}/context/randomfile $root/app/randomfile
+	# Once they've run, the working container doesn't keep them around.
+	run grep -r onbuild-triggers ${TESTDIR}/root/vfs-containers
+	[ "$status" -ne 0 ]
+	buildah delete --name=$newcid
+}
+
+@test "from-onbuild-mount-link" {
+	mkdir -p ${TESTDIR}/context
+	createrandom ${TESTDIR}/context/randomfile
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --onbuild "COPY randomfile /" --onbuild "RUN true"
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:onbuild-image
+	buildah delete --name=$cid
+
+	buildah from --image onbuild-image --mount --link ${TESTDIR}/link --run-onbuild --context ${TESTDIR}/context > ${TESTDIR}/from.txt
+	newcid=$(sed -n 1p ${TESTDIR}/from.txt)
+	root=$(sed -n 2p ${TESTDIR}/from.txt)
+	# The container is still mounted, and the link still points at it.
+	cmp ${TESTDIR}/context/randomfile $root/randomfile
+	cmp ${TESTDIR}/context/randomfile ${TESTDIR}/link/randomfile
+	buildah unmount --name=$newcid
+	buildah delete --name=$newcid
+}
+
+@test "from-onbuild-healthcheck-arg" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --onbuild 'ARG FLAVOR=plain' --onbuild 'LABEL flavor=$FLAVOR' --onbuild 'HEALTHCHECK --interval=30s --retries=3 CMD test -e /tmp'
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:onbuild-image
+	buildah delete --name=$cid
+
+	newcid=$(buildah from --image onbuild-image --run-onbuild)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$newcid --output=dir:${TESTDIR}/image
+	[ "$(dirconfig ${TESTDIR}/image config labels)" = '{"flavor":"plain"}' ]
+	[ "$(dirconfig ${TESTDIR}/image config Healthcheck)" = '{"Interval":30000000000,"Retries":3,"Test":["CMD-SHELL","test -e /tmp"]}' ]
+	buildah delete --name=$newcid
+}
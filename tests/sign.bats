@@ -0,0 +1,44 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup_gpg() {
+	if ! which gpg > /dev/null 2> /dev/null ; then
+		skip "gpg is not installed"
+	fi
+	export GNUPGHOME=${TESTDIR}/gnupg
+	mkdir -m 700 -p ${GNUPGHOME}
+	gpg --batch --passphrase '' --quick-gen-key buildah-test@example.com default default never
+	fingerprint=$(gpg --with-colons --fingerprint buildah-test@example.com | grep '^fpr:' | head -n 1 | cut -d: -f10)
+	gpg --export --armor buildah-test@example.com > ${TESTDIR}/key.gpg
+	cat > ${TESTDIR}/signedby.json <<- _EOF
+	{
+	    "default": [{"type": "reject"}],
+	    "transports": {
+	        "containers-storage": {
+	            "": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "${TESTDIR}/key.gpg"}]
+	        }
+	    }
+	}
+	_EOF
+}
+
+@test "commit-sign-by" {
+	setup_gpg
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --sign-by $fingerprint --output=containers-storage:localhost/signed-image
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:localhost/unsigned-image
+
+	# Signing requires a name to sign the image for.
+	run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --sign-by $fingerprint --output=dir:${TESTDIR}/image
+	[ "$status" -ne 0 ]
+	buildah delete --name=$cid
+
+	# Reading the signed image back has to satisfy a policy which
+	# requires a signature made with our key, and reading the unsigned
+	# one has to fail.
+	signedcid=$(buildah from --pull-always --registry containers-storage: --signature-policy ${TESTDIR}/signedby.json --image localhost/signed-image)
+	buildah delete --name=$signedcid
+	run buildah from --pull-always --registry containers-storage: --signature-policy ${TESTDIR}/signedby.json --image localhost/unsigned-image
+	[ "$status" -ne 0 ]
+}
@@ -0,0 +1,114 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function tarfile() {
+	# Print the contents of a member of a tarball.
+	python3 - "$@" <<- _EOF
+		import sys, tarfile
+		sys.stdout.write(tarfile.open(sys.argv[1]).extractfile(sys.argv[2]).read().decode())
+	_EOF
+}
+
+@test "commit-dir" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/dir
+	test -s ${TESTDIR}/dir/manifest.json
+	[ "$(dirconfig ${TESTDIR}/dir os)" = '"linux"' ]
+	run buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --tag other --output=dir:${TESTDIR}/dir2
+	[ "$status" -ne 0 ]
+	buildah delete --name=$cid
+}
+
+@test "commit-oci" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --tag second --tag third --output=oci:${TESTDIR}/layout:first
+	test -s ${TESTDIR}/layout/oci-layout
+	cmp ${TESTDIR}/layout/refs/first ${TESTDIR}/layout/refs/second
+	cmp ${TESTDIR}/layout/refs/first ${TESTDIR}/layout/refs/third
+	buildah delete --name=$cid
+}
+
+@test "commit-oci-archive" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --tag second --output=oci-archive:${TESTDIR}/oci.tar:first
+	tarfile ${TESTDIR}/oci.tar oci-layout
+	[ "$(tarfile ${TESTDIR}/oci.tar refs/first)" = "$(tarfile ${TESTDIR}/oci.tar refs/second)" ]
+	digest=$(tarfile ${TESTDIR}/oci.tar refs/first | python3 -c 'import json, sys; print(json.load(sys.stdin)["digest"].split(":")[1])')
+	tarfile ${TESTDIR}/oci.tar blobs/sha256/$digest | grep -q application/vnd.oci.image.manifest.v1+json
+	buildah delete --name=$cid
+}
+
+@test "commit-docker-archive" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --tag example.com/other:2 --output=docker-archive:${TESTDIR}/docker.tar:my-image
+	tarfile ${TESTDIR}/docker.tar manifest.json > ${TESTDIR}/manifest.json
+	python3 - ${TESTDIR}/manifest.json <<- _EOF
+		import json, sys
+		manifest = json.load(open(sys.argv[1]))
+		assert len(manifest) == 1, manifest
+		assert manifest[0]["RepoTags"] == ["my-image:latest", "example.com/other:2"], manifest
+		assert manifest[0]["Config"].endswith(".json"), manifest
+		assert len(manifest[0]["Layers"]) > 0, manifest
+	_EOF
+	buildah delete --name=$cid
+}
+
+@test "push-archives" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image
+	buildah delete --name=$cid
+	buildah push --signature-policy ${TESTSDIR}/policy.json --image new-image --tag extra --output=oci-archive:${TESTDIR}/oci.tar:pushed
+	[ "$(tarfile ${TESTDIR}/oci.tar refs/pushed)" = "$(tarfile ${TESTDIR}/oci.tar refs/extra)" ]
+	buildah push --signature-policy ${TESTSDIR}/policy.json --image new-image --output=docker-archive:${TESTDIR}/docker.tar:new-image:pushed
+	tarfile ${TESTDIR}/docker.tar manifest.json | grep -q '"new-image:pushed"'
+	buildah push --signature-policy ${TESTSDIR}/policy.json --image new-image --tag yet-another-name --output=containers-storage:other-name
+	cid=$(buildah from --image yet-another-name)
+	buildah delete --name=$cid
+	run buildah push --signature-policy ${TESTSDIR}/policy.json --image no-such-image --output=dir:${TESTDIR}/dir
+	[ "$status" -ne 0 ]
+}
+
+@test "from-transports" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=oci:${TESTDIR}/layout:first
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=oci-archive:${TESTDIR}/oci.tar:first
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=docker-archive:${TESTDIR}/docker.tar:my-image
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=dir:${TESTDIR}/dir
+	buildah delete --name=$cid
+	for image in oci:${TESTDIR}/layout:first oci-archive:${TESTDIR}/oci.tar:first docker-archive:${TESTDIR}/docker.tar docker-archive:${TESTDIR}/docker.tar:my-image dir:${TESTDIR}/dir ; do
+		cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json --image $image)
+		buildah list --quiet | grep -q " $image $cid\$"
+		buildah delete --name=$cid
+	done
+	cid=$(buildah from --image my-image)
+	buildah delete --name=$cid
+	# Using the same image again doesn't add any names to it, and the
+	# locations aren't used as names.
+	cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json --image docker-archive:${TESTDIR}/docker.tar:my-image)
+	buildah delete --name=$cid
+	python3 - ${TESTDIR}/root/vfs-images/images.json <<- _EOF
+		import json, sys
+		for image in json.load(open(sys.argv[1])):
+		    names = image.get("names") or []
+		    assert len(names) == len(set(names)), image
+		    assert not any(name.startswith(("oci:", "oci-archive:", "docker-archive:", "dir:")) for name in names), image
+	_EOF
+	run buildah from --signature-policy ${TESTSDIR}/policy.json --image docker-archive:${TESTDIR}/docker.tar:no-such-image
+	[ "$status" -ne 0 ]
+}
+
+@test "commit-multiple-outputs" {
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json --image alpine)
+	buildah config --name=$cid --label a=b
+	buildah commit --signature-policy ${TESTSDIR}/policy.json --name=$cid --output=containers-storage:new-image --output=dir:${TESTDIR}/dir --output=oci:${TESTDIR}/layout:first --output=docker-archive:${TESTDIR}/docker.tar:my-image
+	buildah delete --name=$cid
+	[ "$(dirconfig ${TESTDIR}/dir config labels a)" = '"b"' ]
+	config=$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))["config"]["digest"].split(":")[1])' ${TESTDIR}/dir/manifest.json)
+	test -s ${TESTDIR}/layout/blobs/sha256/$config
+	tarfile ${TESTDIR}/docker.tar manifest.json | grep -q "\"$config.json\""
+	cid=$(buildah from --image new-image)
+	buildah delete --name=$cid
+	cid=$(buildah from --image docker-archive:${TESTDIR}/docker.tar)
+	buildah delete --name=$cid
+}
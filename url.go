@@ -0,0 +1,139 @@
+package buildah
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultURLRetryDelay is how long we wait before retrying a failed
+	// download, if no other value is specified.
+	DefaultURLRetryDelay = 2 * time.Second
+)
+
+// URLOptions control how content is retrieved from http and https URLs.
+type URLOptions struct {
+	// Headers is a set of additional headers to send with requests.
+	Headers http.Header
+	// BearerToken, if set, is sent in an Authorization header.
+	BearerToken string
+	// Username and Password, if Username is set, are used for HTTP basic
+	// authentication.
+	Username string
+	Password string
+	// CAFile is the path of a file containing PEM-encoded certificates of
+	// certificate authorities which should be trusted, in addition to the
+	// system's defaults, when verifying servers' certificates.
+	CAFile string
+	// Insecure disables verification of servers' certificates.
+	Insecure bool
+	// Proxy is the URL of a proxy to use.  If not set, the proxy is
+	// chosen based on the environment, in the usual way.
+	Proxy string
+	// Timeout limits how long any one attempt to retrieve a URL can take,
+	// including reading the contents.  Zero means no limit.
+	Timeout time.Duration
+	// Retries is the number of times to retry a request if it fails
+	// because of a network error or a server error.
+	Retries int
+	// RetryDelay is how long to wait between attempts.  If not set,
+	// DefaultURLRetryDelay is used.
+	RetryDelay time.Duration
+}
+
+// newHTTPClient builds a client for retrieving URLs using the options.
+func newHTTPClient(options URLOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: options.Insecure,
+	}
+	if options.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logrus.Debugf("error loading system certificate pool, starting with an empty one: %v", err)
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificates from %q: %v", options.CAFile, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %q", options.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	proxy := http.ProxyFromEnvironment
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy URL %q: %v", options.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+	}, nil
+}
+
+// retryableStatus returns true if a request which got the status code in
+// response might succeed if we try it again.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// getURL starts retrieving the contents of a URL, retrying as directed by the
+// options.  The caller is expected to close the response's body.
+func getURL(client *http.Client, srcurl string, options URLOptions) (*http.Response, error) {
+	delay := options.RetryDelay
+	if delay == 0 {
+		delay = DefaultURLRetryDelay
+	}
+	var lastErr error
+	for attempt := 0; attempt <= options.Retries; attempt++ {
+		if attempt > 0 {
+			logrus.Debugf("retrying %q in %v after error: %v", srcurl, delay, lastErr)
+			time.Sleep(delay)
+		}
+		req, err := http.NewRequest("GET", srcurl, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error building request for %q: %v", srcurl, err)
+		}
+		for name, values := range options.Headers {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+		if options.Username != "" {
+			req.SetBasicAuth(options.Username, options.Password)
+		}
+		if options.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+options.BearerToken)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+			return resp, nil
+		}
+		resp.Body.Close()
+		lastErr = fmt.Errorf("server returned %q", resp.Status)
+		if !retryableStatus(resp.StatusCode) {
+			break
+		}
+	}
+	return nil, fmt.Errorf("error getting %q: %v", srcurl, lastErr)
+}
@@ -0,0 +1,137 @@
+package buildah
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/system"
+)
+
+// listXattrs returns the names of the extended attributes which are set on
+// a file.  Symbolic links are not followed, and are treated as having none.
+func listXattrs(path string) ([]string, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// copiedXattr checks if an extended attribute is one that we carry over when
+// copying files or adding them to layers: file capabilities, and attributes
+// in the "user" namespace.  Others, particularly SELinux labels, describe
+// the host that we're running on rather than the image's contents.
+func copiedXattr(name string) bool {
+	return name == "security.capability" || strings.HasPrefix(name, "user.")
+}
+
+// getXattrs reads the extended attributes which are set on a file, skipping
+// those which copiedXattr rejects.
+func getXattrs(path string) (map[string][]byte, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string][]byte)
+	for _, name := range names {
+		if !copiedXattr(name) {
+			continue
+		}
+		value, err := system.Lgetxattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+// setXattrs sets extended attributes on a file.  Attributes which the
+// filesystem doesn't support are skipped.
+func setXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := system.Lsetxattr(path, name, value, 0); err != nil {
+			if err == syscall.ENOTSUP {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// addXattrsToLayer reads an uncompressed layer diff and returns a copy of it
+// in which each entry carries the extended attributes that are set on the
+// corresponding file under root, where the layer is mounted, and which
+// copiedXattr accepts.  Layer diffs that we get from the Store only include
+// file capabilities.
+func addXattrsToLayer(diff io.Reader, root string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		tr := tar.NewReader(diff)
+		tw := tar.NewWriter(writer)
+		var err error
+		for {
+			var hdr *tar.Header
+			hdr, err = tr.Next()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+			if !strings.HasPrefix(filepath.Base(hdr.Name), archive.WhiteoutPrefix) {
+				xattrs, err2 := getXattrs(filepath.Join(root, filepath.Clean(string(os.PathSeparator)+hdr.Name)))
+				if err2 == nil && len(xattrs) > 0 {
+					if hdr.Xattrs == nil {
+						hdr.Xattrs = make(map[string]string)
+					}
+					for name, value := range xattrs {
+						hdr.Xattrs[name] = string(value)
+					}
+				}
+			}
+			if err = tw.WriteHeader(hdr); err != nil {
+				break
+			}
+			if _, err = io.Copy(tw, tr); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}